@@ -2,7 +2,11 @@ package tools
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -10,12 +14,48 @@ import (
 
 var ctxB = context.Background()
 
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
 type RedisConfiguration struct {
 	Host     string
 	Port     string
 	Password string
 	Prefix   string
 	UseMock  bool
+
+	// topology, when Mode is empty it is sentinel if MasterName is set,
+	// cluster if Addrs has more than one address, otherwise single node.
+	// Set Mode to cluster when Addrs hold a single seed node of a cluster.
+	Mode       string
+	Addrs      []string //cluster nodes or sentinel addresses as host:port
+	MasterName string   //sentinel master name
+	DB         int      //ignored on cluster
+	Username   string   //ACL user
+
+	SentinelUsername string
+	SentinelPassword string
+
+	TLS           bool
+	TLSCACert     string //path to CA certificate, system pool when empty
+	TLSCert       string //path to client certificate
+	TLSKey        string //path to client key
+	TLSSkipVerify bool
+
+	// pool tuning, zero keep go-redis default
+	PoolSize        int
+	MinIdleConns    int
+	MaxIdleConns    int
+	PoolTimeout     time.Duration
+	ConnMaxIdleTime time.Duration
+	ConnMaxLifetime time.Duration
+	DialTimeout     time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	MaxRetries      int
 }
 
 func NewRedisClient(url, port, password string, dbIndex int) *redis.Client {
@@ -26,6 +66,135 @@ func NewRedisClient(url, port, password string, dbIndex int) *redis.Client {
 	})
 }
 
+// RedisMode return configured topology, inferred when Mode is empty
+func (cfg RedisConfiguration) RedisMode() string {
+	mode := strings.ToLower(strings.TrimSpace(cfg.Mode))
+	switch {
+	case mode != "":
+		return mode
+	case cfg.MasterName != "":
+		return RedisModeSentinel
+	case len(cfg.Addrs) > 1:
+		return RedisModeCluster
+	default:
+		return RedisModeSingle
+	}
+}
+
+// NewUniversalRedisClient build single node, sentinel or cluster client from configuration
+func NewUniversalRedisClient(cfg RedisConfiguration) (redis.UniversalClient, error) {
+	tlsConfig, err := redisTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := cfg.Addrs
+	if len(addrs) == 0 && cfg.Host != "" {
+		addrs = []string{cfg.Host + ":" + cfg.Port}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("redis address is required")
+	}
+
+	switch cfg.RedisMode() {
+	case RedisModeSentinel:
+		if cfg.MasterName == "" {
+			return nil, fmt.Errorf("redis sentinel master name is required")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    addrs,
+			SentinelUsername: cfg.SentinelUsername,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			MaxIdleConns:     cfg.MaxIdleConns,
+			PoolTimeout:      cfg.PoolTimeout,
+			ConnMaxIdleTime:  cfg.ConnMaxIdleTime,
+			ConnMaxLifetime:  cfg.ConnMaxLifetime,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+			MaxRetries:       cfg.MaxRetries,
+		}), nil
+	case RedisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           addrs,
+			Username:        cfg.Username,
+			Password:        cfg.Password,
+			TLSConfig:       tlsConfig,
+			PoolSize:        cfg.PoolSize,
+			MinIdleConns:    cfg.MinIdleConns,
+			MaxIdleConns:    cfg.MaxIdleConns,
+			PoolTimeout:     cfg.PoolTimeout,
+			ConnMaxIdleTime: cfg.ConnMaxIdleTime,
+			ConnMaxLifetime: cfg.ConnMaxLifetime,
+			DialTimeout:     cfg.DialTimeout,
+			ReadTimeout:     cfg.ReadTimeout,
+			WriteTimeout:    cfg.WriteTimeout,
+			MaxRetries:      cfg.MaxRetries,
+		}), nil
+	case RedisModeSingle:
+		return redis.NewClient(&redis.Options{
+			Addr:            addrs[0],
+			Username:        cfg.Username,
+			Password:        cfg.Password,
+			DB:              cfg.DB,
+			TLSConfig:       tlsConfig,
+			PoolSize:        cfg.PoolSize,
+			MinIdleConns:    cfg.MinIdleConns,
+			MaxIdleConns:    cfg.MaxIdleConns,
+			PoolTimeout:     cfg.PoolTimeout,
+			ConnMaxIdleTime: cfg.ConnMaxIdleTime,
+			ConnMaxLifetime: cfg.ConnMaxLifetime,
+			DialTimeout:     cfg.DialTimeout,
+			ReadTimeout:     cfg.ReadTimeout,
+			WriteTimeout:    cfg.WriteTimeout,
+			MaxRetries:      cfg.MaxRetries,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unknown redis mode %s", cfg.RedisMode())
+}
+
+func redisTLSConfig(cfg RedisConfiguration) (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.TLSSkipVerify,
+	}
+
+	if cfg.TLSCACert != "" {
+		pem, err := os.ReadFile(cfg.TLSCACert)
+		if err != nil {
+			return nil, fmt.Errorf("fail read redis ca cert : %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("fail parse redis ca cert %s", cfg.TLSCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("fail load redis client cert : %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
 // this is useful for testing, to predefined behavior of the response
 type cacherResponse struct {
 	Key   string
@@ -53,11 +222,13 @@ func NewCacher(rdc *redis.Client, prefix string, expiracy int) Cacher {
 	}
 }
 
+// Deprecated: use FakeCacher.FailOnce in tests instead.
 func (c *Cacher) SetForcedError(err error) {
 	c.forced = true
 	c.err = err
 }
 
+// Deprecated: use FakeCacher in tests instead.
 func (c *Cacher) SetResponse(key, tipe, value string, err error) {
 	c.responses[key+"_"+tipe] = cacherResponse{
 		Key:   key,
@@ -68,22 +239,13 @@ func (c *Cacher) SetResponse(key, tipe, value string, err error) {
 }
 
 func (c *Cacher) PrintKeys() {
-	var cursor uint64
-	for {
-		var keys []string
-		var err error
-		keys, cursor, err = c.rdb.Scan(ctxB, cursor, "", 0).Result()
-		if err != nil {
-			panic(err)
-		}
-
-		for _, key := range keys {
-			fmt.Println("key", key)
-		}
+	it := scanKeys(ctxB, c.rdb, c.prefix+"_*", defaultScanBatch)
+	for it.Next() {
+		fmt.Println("key", it.Key())
+	}
 
-		if cursor == 0 { // no more keys
-			break
-		}
+	if err := it.Err(); err != nil {
+		panic(err)
 	}
 }
 
@@ -204,5 +366,5 @@ func (c *Cacher) GetKeysWithParam(name string) ([]string, error) {
 	}
 
 	c.addRegister(name)
-	return c.rdb.Keys(ctxB, c.prefix+"_"+name).Result()
+	return collectKeys(scanKeys(ctxB, c.rdb, c.prefix+"_"+name, defaultScanBatch))
 }
@@ -0,0 +1,177 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CacheResult is outcome of single operation inside a batch,
+// Err is ErrCacheMiss when the key of get or delete does not exist
+type CacheResult struct {
+	Op    string
+	Name  string
+	Value string
+	Err   error
+}
+
+// CacheBatch queue operations to be sent in one round-trip, names are prefixed automatically
+type CacheBatch interface {
+	Get(name string)
+	Set(name string, value string, d time.Duration) //d 0 use cacher expiracy
+	Delete(name string)
+}
+
+// sorted names of map so MSet results are stable
+func sortedNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mgetBatch(names []string) func(b CacheBatch) {
+	return func(b CacheBatch) {
+		for _, name := range names {
+			b.Get(name)
+		}
+	}
+}
+
+func msetBatch(values map[string]string, ttl time.Duration) func(b CacheBatch) {
+	return func(b CacheBatch) {
+		for _, name := range sortedNames(values) {
+			b.Set(name, values[name], ttl)
+		}
+	}
+}
+
+func deleteBatch(names []string) func(b CacheBatch) {
+	return func(b CacheBatch) {
+		for _, name := range names {
+			b.Delete(name)
+		}
+	}
+}
+
+func (c *cacher) MGet(names ...string) ([]CacheResult, error) {
+	return c.MGetCtx(ctxB, names...)
+}
+
+func (c *cacher) MGetCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, mgetBatch(names))
+}
+
+func (c *cacher) MSet(values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return c.MSetCtx(ctxB, values, ttl)
+}
+
+func (c *cacher) MSetCtx(ctx context.Context, values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, msetBatch(values, ttl))
+}
+
+func (c *cacher) DeleteMany(names ...string) ([]CacheResult, error) {
+	return c.DeleteManyCtx(ctxB, names...)
+}
+
+func (c *cacher) DeleteManyCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, deleteBatch(names))
+}
+
+func (c *cacher) Pipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.PipelineCtx(ctxB, fn)
+}
+
+func (c *cacher) PipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.runBatch(ctx, c.rdb.Pipeline(), fn)
+}
+
+func (c *cacher) TxPipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.TxPipelineCtx(ctxB, fn)
+}
+
+func (c *cacher) TxPipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.runBatch(ctx, c.rdb.TxPipeline(), fn)
+}
+
+func (c *cacher) runBatch(ctx context.Context, p redis.Pipeliner, fn func(b CacheBatch)) ([]CacheResult, error) {
+	b := &redisBatch{c: c, ctx: ctx, p: p}
+	fn(b)
+
+	if len(b.results) == 0 {
+		return b.results, nil
+	}
+
+	//exec error is only the first failed command, every command carry its own
+	_, err := p.Exec(ctx)
+
+	failed := 0
+	for i, cmd := range b.cmds {
+		b.results[i] = redisBatchResult(b.results[i], cmd)
+		if b.results[i].Err != nil && !errors.Is(b.results[i].Err, ErrCacheMiss) {
+			failed++
+		}
+	}
+
+	//the round-trip itself failed
+	if err != nil && failed == len(b.cmds) {
+		return b.results, err
+	}
+
+	return b.results, nil
+}
+
+type redisBatch struct {
+	c       *cacher
+	ctx     context.Context
+	p       redis.Pipeliner
+	cmds    []redis.Cmder
+	results []CacheResult
+}
+
+func (b *redisBatch) add(op, name, value string, cmd redis.Cmder) {
+	b.cmds = append(b.cmds, cmd)
+	b.results = append(b.results, CacheResult{Op: op, Name: name, Value: value})
+}
+
+func (b *redisBatch) Get(name string) {
+	b.add(CacheOpGet, name, "", b.p.Get(b.ctx, b.c.key(name)))
+}
+
+func (b *redisBatch) Set(name string, value string, d time.Duration) {
+	if d <= 0 {
+		d = b.c.expiracy
+	}
+	b.add(CacheOpSet, name, value, b.p.Set(b.ctx, b.c.key(name), value, d))
+}
+
+func (b *redisBatch) Delete(name string) {
+	b.add(CacheOpDelete, name, "", b.p.Del(b.ctx, b.c.key(name)))
+}
+
+func redisBatchResult(res CacheResult, cmd redis.Cmder) CacheResult {
+	switch cmd := cmd.(type) {
+	case *redis.StringCmd:
+		val, err := cmd.Result()
+		if errors.Is(err, redis.Nil) || val == negativeMarker {
+			res.Err = ErrCacheMiss
+			return res
+		}
+		res.Value, res.Err = val, err
+	case *redis.IntCmd:
+		n, err := cmd.Result()
+		res.Err = err
+		if err == nil && n == 0 {
+			res.Err = ErrCacheMiss
+		}
+	default:
+		res.Err = cmd.Err()
+	}
+
+	return res
+}
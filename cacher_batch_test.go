@@ -0,0 +1,95 @@
+package tools
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// every implementation should satisfy the interface
+var (
+	_ CacherV2 = (*cacher)(nil)
+	_ CacherV2 = (*MemoryCacher)(nil)
+	_ CacherV2 = (*TieredCacher)(nil)
+	_ CacherV2 = (*FakeCacher)(nil)
+)
+
+func TestCacherBatch(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	memory := NewMemoryCacher("batch", 60, 0)
+	defer memory.Close()
+
+	for name, c := range map[string]CacherV2{"redis": NewCacherV2(rds, "batch", 60), "memory": memory} {
+		t.Run(name, func(t *testing.T) {
+			res, err := c.MSet(map[string]string{"b": "2", "a": "1"}, time.Minute)
+			assert.Nil(t, err, "should nil")
+			assert.Equal(t, []CacheResult{
+				{Op: CacheOpSet, Name: "a", Value: "1"},
+				{Op: CacheOpSet, Name: "b", Value: "2"},
+			}, res, "should report every key")
+
+			//partial miss does not fail the batch
+			res, err = c.MGet("a", "missing", "b")
+			assert.Nil(t, err, "should nil")
+			assert.Equal(t, "1", res[0].Value)
+			assert.ErrorIs(t, res[1].Err, ErrCacheMiss, "should be miss")
+			assert.Equal(t, "2", res[2].Value)
+
+			val, err := c.Get("a")
+			assert.Nil(t, err, "prefix should be applied")
+			assert.Equal(t, "1", val)
+
+			res, err = c.DeleteMany("a", "missing")
+			assert.Nil(t, err, "should nil")
+			assert.Nil(t, res[0].Err, "existing key should be deleted")
+			assert.ErrorIs(t, res[1].Err, ErrCacheMiss, "missing key should be reported")
+
+			//operations run in order inside transaction
+			res, err = c.TxPipeline(func(b CacheBatch) {
+				b.Set("c", "3", 0)
+				b.Get("c")
+				b.Delete("b")
+			})
+			assert.Nil(t, err, "should nil")
+			assert.Len(t, res, 3)
+			assert.Equal(t, "3", res[1].Value, "should read own write")
+			assert.Nil(t, res[2].Err, "should delete")
+		})
+	}
+}
+
+func TestTieredCacherBatch(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	a, err := NewTieredCacher(rds, "tier", 60, TieredCacheConfig{})
+	assert.Nil(t, err)
+	defer a.Close()
+	b, err := NewTieredCacher(rds, "tier", 60, TieredCacheConfig{})
+	assert.Nil(t, err)
+	defer b.Close()
+
+	_, err = a.MSet(map[string]string{"x": "1", "y": "2"}, 0)
+	assert.Nil(t, err, "should nil")
+
+	res, err := b.MGet("x", "y", "z")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "1", res[0].Value)
+	assert.Equal(t, "2", res[1].Value)
+	assert.ErrorIs(t, res[2].Err, ErrCacheMiss)
+
+	//second read come from memory
+	_, err = b.MGet("x", "y")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, uint64(2), b.Stats().LocalHits, "should hit local tier")
+
+	_, err = a.MSet(map[string]string{"x": "10"}, 0)
+	assert.Nil(t, err, "should nil")
+	assert.Eventually(t, func() bool {
+		val, _ := b.Get("x")
+		return val == "10"
+	}, time.Second, 10*time.Millisecond, "batch write should invalidate other replica")
+}
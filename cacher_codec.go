@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ErrCacheMiss is returned by the typed helpers when the key does not exist
+var ErrCacheMiss = errors.New("cache miss")
+
+// Codec serialize value before it is stored in cacher
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// GetJSON read key from cacher and decode it as JSON
+func GetJSON[T any](c CacherV2, name string) (T, error) {
+	return GetWithCodec[T](c, JSONCodec, name)
+}
+
+// SetJSON encode value as JSON and store it with default expiracy
+func SetJSON(c CacherV2, name string, value interface{}) error {
+	return SetWithCodec(c, JSONCodec, name, value)
+}
+
+// SetJSONWithDuration encode value as JSON and store it with given duration
+func SetJSONWithDuration(c CacherV2, name string, value interface{}, d time.Duration) error {
+	return SetWithCodecDuration(c, JSONCodec, name, value, d)
+}
+
+// GetWithCodec read key from cacher and decode it using codec,
+// return ErrCacheMiss when key is not exist
+func GetWithCodec[T any](c CacherV2, codec Codec, name string) (T, error) {
+	var result T
+
+	raw, err := c.Get(name)
+	if errors.Is(err, redis.Nil) {
+		return result, ErrCacheMiss
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if err := codec.Unmarshal([]byte(raw), &result); err != nil {
+		return result, fmt.Errorf("fail decode cache %s : %w", name, err)
+	}
+
+	return result, nil
+}
+
+// SetWithCodec encode value using codec and store it with default expiracy
+func SetWithCodec(c CacherV2, codec Codec, name string, value interface{}) error {
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("fail encode cache %s : %w", name, err)
+	}
+
+	return c.Set(name, string(data))
+}
+
+// SetWithCodecDuration encode value using codec and store it with given duration
+func SetWithCodecDuration(c CacherV2, codec Codec, name string, value interface{}, d time.Duration) error {
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("fail encode cache %s : %w", name, err)
+	}
+
+	return c.SetWithDuration(name, string(data), d)
+}
@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cachedProfile struct {
+	ID   int64
+	Name string
+	Tags []string
+}
+
+func TestCacherCodec(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	c := NewCacherV2(rds, "codec", 60)
+	profile := cachedProfile{ID: 7, Name: "john", Tags: []string{"a", "b"}}
+
+	//json is the default codec
+	err = SetJSON(c, "profile", profile)
+	assert.Nil(t, err, "should nil")
+
+	got, err := GetJSON[cachedProfile](c, "profile")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, profile, got, "should have same value")
+
+	for name, codec := range map[string]Codec{"gob": GobCodec, "msgpack": MsgpackCodec} {
+		err = SetWithCodec(c, codec, name, profile)
+		assert.Nil(t, err, "should nil")
+
+		got, err = GetWithCodec[cachedProfile](c, codec, name)
+		assert.Nil(t, err, "should nil")
+		assert.Equal(t, profile, got, "should have same value for "+name)
+	}
+
+	//missing key return sentinel error
+	_, err = GetJSON[cachedProfile](c, "missing")
+	assert.ErrorIs(t, err, ErrCacheMiss, "should be cache miss")
+
+	//broken data return decode error
+	err = c.Set("broken", "{")
+	assert.Nil(t, err, "should nil")
+	_, err = GetJSON[cachedProfile](c, "broken")
+	assert.NotNil(t, err, "should fail decode")
+	assert.NotErrorIs(t, err, ErrCacheMiss, "should not be cache miss")
+}
@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// stored in place of the value when the loader report a miss
+const negativeMarker = "\x00tools:negative"
+
+type cacherOptions struct {
+	negativeTTL time.Duration
+	jitter      float64
+}
+
+type CacherOption func(*cacherOptions)
+
+// WithNegativeTTL cache a loader miss (loader return ErrCacheMiss) for duration d,
+// so repeated lookups of a missing key don't reach the loader
+func WithNegativeTTL(d time.Duration) CacherOption {
+	return func(o *cacherOptions) {
+		o.negativeTTL = d
+	}
+}
+
+// WithTTLJitter add up to ratio*ttl random extra time to ttl used by GetOrLoad,
+// ratio 0.1 means ttl of 60s become anything between 60s and 66s
+func WithTTLJitter(ratio float64) CacherOption {
+	return func(o *cacherOptions) {
+		o.jitter = ratio
+	}
+}
+
+func newCacherOptions(opts []CacherOption) cacherOptions {
+	o := cacherOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func jitterTTL(ttl time.Duration, ratio float64) time.Duration {
+	if ratio <= 0 || ttl <= 0 {
+		return ttl
+	}
+
+	return ttl + time.Duration(rand.Float64()*ratio*float64(ttl))
+}
+
+// loaderStore is implemented by cachers that support GetOrLoad
+type loaderStore interface {
+	getRaw(ctx context.Context, name string) (string, error)
+	SetWithDurationCtx(ctx context.Context, name string, value string, d time.Duration) error
+}
+
+func getOrLoad(ctx context.Context, c loaderStore, group *singleflight.Group, opts cacherOptions, name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	val, err := c.getRaw(ctx, name)
+	if err == nil && val == negativeMarker {
+		return "", ErrCacheMiss
+	}
+	if err == nil {
+		return val, nil
+	}
+
+	//any read error fall back to loader, cache is only an optimization
+	v, err, _ := group.Do(name, func() (interface{}, error) {
+		val, err := loader()
+		if errors.Is(err, ErrCacheMiss) {
+			if opts.negativeTTL > 0 {
+				c.SetWithDurationCtx(ctx, name, negativeMarker, opts.negativeTTL)
+			}
+			return "", ErrCacheMiss
+		}
+		if err != nil {
+			return "", err
+		}
+
+		//failing to write cache should not fail the read
+		c.SetWithDurationCtx(ctx, name, val, jitterTTL(ttl, opts.jitter))
+		return val, nil
+	})
+
+	return v.(string), err
+}
@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	c := NewCacherV2(rds, "load", 60, WithNegativeTTL(time.Minute))
+
+	//concurrent misses should call loader once
+	var calls int32
+	loader := func() (string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "loaded", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := c.GetOrLoad("hot", time.Minute, loader)
+			assert.Nil(t, err, "should nil")
+			assert.Equal(t, "loaded", val, "should have loaded value")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "loader should be called once")
+
+	val, err := c.Get("hot")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "loaded", val, "value should be cached")
+
+	//negative result is cached
+	calls = 0
+	missing := func() (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", ErrCacheMiss
+	}
+	_, err = c.GetOrLoad("missing", time.Minute, missing)
+	assert.ErrorIs(t, err, ErrCacheMiss, "should be cache miss")
+	_, err = c.GetOrLoad("missing", time.Minute, missing)
+	assert.ErrorIs(t, err, ErrCacheMiss, "should be cache miss")
+	assert.Equal(t, int32(1), calls, "loader should be called once")
+
+	//negative marker is hidden from plain get
+	_, err = c.Get("missing")
+	assert.NotNil(t, err, "should not return marker")
+
+	//loader error is not cached
+	failing := errors.New("db down")
+	_, err = c.GetOrLoad("failing", time.Minute, func() (string, error) { return "", failing })
+	assert.ErrorIs(t, err, failing, "should return loader error")
+	_, err = c.Get("failing")
+	assert.NotNil(t, err, "error should not be cached")
+}
+
+func TestJitterTTL(t *testing.T) {
+	ttl := time.Minute
+	assert.Equal(t, ttl, jitterTTL(ttl, 0), "no jitter should keep ttl")
+
+	for i := 0; i < 100; i++ {
+		d := jitterTTL(ttl, 0.1)
+		assert.GreaterOrEqual(t, d, ttl)
+		assert.LessOrEqual(t, d, ttl+6*time.Second)
+	}
+}
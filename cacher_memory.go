@@ -0,0 +1,441 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// MemoryCacher is CacherV2 kept in process memory, useful for tests and
+// single node tools. Missing key return redis.Nil like the redis cacher.
+type MemoryCacher struct {
+	mu       sync.RWMutex
+	items    map[string]memoryItem
+	tags     map[string]map[string]struct{} //tag to full keys
+	expiracy time.Duration
+	prefix   string
+	opts     cacherOptions
+	group    singleflight.Group
+	stop     chan struct{}
+	once     sync.Once
+}
+
+type memoryItem struct {
+	value   string
+	expires time.Time //zero mean no expiry
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expires.IsZero() && now.After(i.expires)
+}
+
+// NewMemoryCacher create in memory cacher, expired keys are removed every cleanup interval
+func NewMemoryCacher(prefix string, expiracy int, cleanup time.Duration, opts ...CacherOption) *MemoryCacher {
+	c := &MemoryCacher{
+		items:    make(map[string]memoryItem),
+		tags:     make(map[string]map[string]struct{}),
+		expiracy: time.Duration(expiracy) * time.Second,
+		prefix:   prefix,
+		opts:     newCacherOptions(opts),
+		stop:     make(chan struct{}),
+	}
+
+	if cleanup > 0 {
+		go c.janitor(cleanup)
+	}
+
+	return c
+}
+
+func (c *MemoryCacher) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.deleteExpired()
+		}
+	}
+}
+
+func (c *MemoryCacher) deleteExpired() {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, item := range c.items {
+		if item.expired(now) {
+			delete(c.items, key)
+		}
+	}
+
+	for tag, keys := range c.tags {
+		for key := range keys {
+			if _, ok := c.items[key]; !ok {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// Close stop the janitor
+func (c *MemoryCacher) Close() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *MemoryCacher) key(name string) string {
+	return c.prefix + "_" + name
+}
+
+// keys with prefix matching pattern, sorted for stable iteration
+func (c *MemoryCacher) matchKeys(pattern string) []string {
+	now := time.Now()
+	full := c.key(pattern)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := []string{}
+	for key, item := range c.items {
+		if !item.expired(now) && matchGlob(full, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *MemoryCacher) Ping() error {
+	return c.PingCtx(ctxB)
+}
+
+func (c *MemoryCacher) Get(name string) (string, error) {
+	return c.GetCtx(ctxB, name)
+}
+
+func (c *MemoryCacher) Set(name string, value string) error {
+	return c.SetCtx(ctxB, name, value)
+}
+
+func (c *MemoryCacher) SetWithDuration(name string, value string, d time.Duration) error {
+	return c.SetWithDurationCtx(ctxB, name, value, d)
+}
+
+func (c *MemoryCacher) Delete(name string) error {
+	return c.DeleteCtx(ctxB, name)
+}
+
+func (c *MemoryCacher) GetKeysWithParam(name string) ([]string, error) {
+	return c.GetKeysWithParamCtx(ctxB, name)
+}
+
+func (c *MemoryCacher) PrintKeys() {
+	for _, key := range c.matchKeys("*") {
+		fmt.Println("key", key)
+	}
+}
+
+func (c *MemoryCacher) PingCtx(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (c *MemoryCacher) getRaw(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	c.mu.RLock()
+	item, ok := c.items[c.key(name)]
+	c.mu.RUnlock()
+
+	if !ok || item.expired(time.Now()) {
+		return "", redis.Nil
+	}
+
+	return item.value, nil
+}
+
+func (c *MemoryCacher) GetCtx(ctx context.Context, name string) (string, error) {
+	val, err := c.getRaw(ctx, name)
+	if err == nil && val == negativeMarker {
+		return "", redis.Nil
+	}
+	return val, err
+}
+
+func (c *MemoryCacher) SetCtx(ctx context.Context, name string, value string) error {
+	return c.SetWithDurationCtx(ctx, name, value, c.expiracy)
+}
+
+func (c *MemoryCacher) SetWithDurationCtx(ctx context.Context, name string, value string, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	item := memoryItem{value: value}
+	if d > 0 {
+		item.expires = time.Now().Add(d)
+	}
+
+	c.mu.Lock()
+	c.items[c.key(name)] = item
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *MemoryCacher) DeleteCtx(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.items, c.key(name))
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *MemoryCacher) GetKeysWithParamCtx(ctx context.Context, name string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.matchKeys(name), nil
+}
+
+func (c *MemoryCacher) GetOrLoad(name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	return c.GetOrLoadCtx(ctxB, name, ttl, loader)
+}
+
+func (c *MemoryCacher) GetOrLoadCtx(ctx context.Context, name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	if ttl <= 0 {
+		ttl = c.expiracy
+	}
+
+	return getOrLoad(ctx, c, &c.group, c.opts, name, ttl, loader)
+}
+
+func (c *MemoryCacher) ScanKeys(pattern string, batch int64) KeyIterator {
+	return c.ScanKeysCtx(ctxB, pattern, batch)
+}
+
+func (c *MemoryCacher) ScanKeysCtx(ctx context.Context, pattern string, batch int64) KeyIterator {
+	return &sliceKeyIterator{keys: c.matchKeys(pattern), pos: -1, err: ctx.Err()}
+}
+
+func (c *MemoryCacher) DeleteByPattern(pattern string) (int64, error) {
+	return c.DeleteByPatternCtx(ctxB, pattern)
+}
+
+func (c *MemoryCacher) DeleteByPatternCtx(ctx context.Context, pattern string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	keys := c.matchKeys(pattern)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+
+	return int64(len(keys)), nil
+}
+
+// sliceKeyIterator iterate over keys collected up front
+type sliceKeyIterator struct {
+	keys []string
+	pos  int
+	err  error
+}
+
+func (i *sliceKeyIterator) Next() bool {
+	if i.err != nil || i.pos+1 >= len(i.keys) {
+		return false
+	}
+	i.pos++
+	return true
+}
+
+func (i *sliceKeyIterator) Key() string {
+	return i.keys[i.pos]
+}
+
+func (i *sliceKeyIterator) Err() error {
+	return i.err
+}
+
+func (c *MemoryCacher) MGet(names ...string) ([]CacheResult, error) {
+	return c.MGetCtx(ctxB, names...)
+}
+
+func (c *MemoryCacher) MGetCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, mgetBatch(names))
+}
+
+func (c *MemoryCacher) MSet(values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return c.MSetCtx(ctxB, values, ttl)
+}
+
+func (c *MemoryCacher) MSetCtx(ctx context.Context, values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, msetBatch(values, ttl))
+}
+
+func (c *MemoryCacher) DeleteMany(names ...string) ([]CacheResult, error) {
+	return c.DeleteManyCtx(ctxB, names...)
+}
+
+func (c *MemoryCacher) DeleteManyCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, deleteBatch(names))
+}
+
+func (c *MemoryCacher) Pipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.PipelineCtx(ctxB, fn)
+}
+
+// PipelineCtx apply every operation under single lock, so it is atomic like TxPipelineCtx
+func (c *MemoryCacher) PipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	b := &memoryBatch{}
+	fn(b)
+
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, res := range b.results {
+		key := c.key(res.Name)
+		item, ok := c.items[key]
+		exist := ok && !item.expired(now)
+
+		switch res.Op {
+		case CacheOpGet:
+			if !exist || item.value == negativeMarker {
+				res.Err = ErrCacheMiss
+			} else {
+				res.Value = item.value
+			}
+		case CacheOpSet:
+			d := b.ttls[i]
+			if d <= 0 {
+				d = c.expiracy
+			}
+			item = memoryItem{value: res.Value}
+			if d > 0 {
+				item.expires = now.Add(d)
+			}
+			c.items[key] = item
+		case CacheOpDelete:
+			if !exist {
+				res.Err = ErrCacheMiss
+			}
+			delete(c.items, key)
+		}
+
+		b.results[i] = res
+	}
+
+	return b.results, nil
+}
+
+func (c *MemoryCacher) TxPipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.TxPipelineCtx(ctxB, fn)
+}
+
+func (c *MemoryCacher) TxPipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, fn)
+}
+
+type memoryBatch struct {
+	results []CacheResult
+	ttls    []time.Duration
+}
+
+func (b *memoryBatch) Get(name string) {
+	b.results = append(b.results, CacheResult{Op: CacheOpGet, Name: name})
+	b.ttls = append(b.ttls, 0)
+}
+
+func (b *memoryBatch) Set(name string, value string, d time.Duration) {
+	b.results = append(b.results, CacheResult{Op: CacheOpSet, Name: name, Value: value})
+	b.ttls = append(b.ttls, d)
+}
+
+func (b *memoryBatch) Delete(name string) {
+	b.results = append(b.results, CacheResult{Op: CacheOpDelete, Name: name})
+	b.ttls = append(b.ttls, 0)
+}
+
+func (c *MemoryCacher) SetWithTags(name string, value string, ttl time.Duration, tags ...string) error {
+	return c.SetWithTagsCtx(ctxB, name, value, ttl, tags...)
+}
+
+func (c *MemoryCacher) SetWithTagsCtx(ctx context.Context, name string, value string, ttl time.Duration, tags ...string) error {
+	if ttl <= 0 {
+		ttl = c.expiracy
+	}
+
+	if err := c.SetWithDurationCtx(ctx, name, value, ttl); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][c.key(name)] = struct{}{}
+	}
+
+	return nil
+}
+
+func (c *MemoryCacher) InvalidateTag(tag string) (int64, error) {
+	return c.InvalidateTagCtx(ctxB, tag)
+}
+
+func (c *MemoryCacher) InvalidateTagCtx(ctx context.Context, tag string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var deleted int64
+	for key := range c.tags[tag] {
+		if item, ok := c.items[key]; ok && !item.expired(now) {
+			deleted++
+		}
+		delete(c.items, key)
+	}
+	delete(c.tags, tag)
+
+	return deleted, nil
+}
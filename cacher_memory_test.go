@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCacher(t *testing.T) {
+	c := NewMemoryCacher("mem", 60, 10*time.Millisecond)
+	defer c.Close()
+
+	err := c.Set("user_1", "a")
+	assert.Nil(t, err, "should nil")
+	err = c.Set("user_2", "b")
+	assert.Nil(t, err, "should nil")
+	err = c.SetWithDuration("temp", "c", 20*time.Millisecond)
+	assert.Nil(t, err, "should nil")
+
+	val, err := c.Get("user_1")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "a", val, "should have value")
+
+	_, err = c.Get("missing")
+	assert.ErrorIs(t, err, redis.Nil, "miss should behave like redis")
+
+	keys, err := c.GetKeysWithParam("user_*")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, []string{"mem_user_1", "mem_user_2"}, keys, "should match glob")
+
+	//janitor remove expired key
+	assert.Eventually(t, func() bool {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		_, ok := c.items["mem_temp"]
+		return !ok
+	}, time.Second, 10*time.Millisecond, "expired key should be removed")
+
+	deleted, err := c.DeleteByPattern("user_*")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(2), deleted, "should delete matching keys")
+
+	it := c.ScanKeys("*", 10)
+	assert.False(t, it.Next(), "should be empty")
+	assert.Nil(t, it.Err(), "should nil")
+
+	//typed helpers work on memory cacher
+	err = SetJSON(c, "profile", map[string]int{"age": 30})
+	assert.Nil(t, err, "should nil")
+	profile, err := GetJSON[map[string]int](c, "profile")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, 30, profile["age"], "should have value")
+}
+
+func TestFakeCacher(t *testing.T) {
+	f := NewFakeCacher("fake", 60)
+	broken := errors.New("broken")
+
+	f.FailOn(CacheOpGet, "config", broken)
+	f.FailOnce(CacheOpSet, "", broken)
+
+	//first set on any key fail once
+	assert.ErrorIs(t, f.Set("a", "1"), broken, "should fail once")
+	assert.Nil(t, f.Set("a", "1"), "should succeed after once")
+
+	val, err := f.Get("a")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "1", val, "should have value")
+
+	_, err = f.Get("config")
+	assert.ErrorIs(t, err, broken, "should fail on scripted key")
+	_, err = f.Get("config")
+	assert.ErrorIs(t, err, broken, "should keep failing")
+
+	f.ClearFailures()
+	_, err = f.Get("config")
+	assert.ErrorIs(t, err, redis.Nil, "should reach memory after clear")
+
+	assert.Equal(t, 2, f.CallCount(CacheOpSet), "should record set calls")
+	assert.Equal(t, []CacherCall{
+		{Op: CacheOpSet, Name: "a", Value: "1"},
+		{Op: CacheOpSet, Name: "a", Value: "1"},
+		{Op: CacheOpGet, Name: "a"},
+		{Op: CacheOpGet, Name: "config"},
+		{Op: CacheOpGet, Name: "config"},
+		{Op: CacheOpGet, Name: "config"},
+	}, f.Calls(), "should record calls in order")
+}
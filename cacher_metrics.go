@@ -0,0 +1,342 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// result label values of cache_operations_total
+const (
+	cacheResultHit   = "hit"
+	cacheResultMiss  = "miss"
+	cacheResultOK    = "ok"
+	cacheResultError = "error"
+)
+
+type InstrumentedCacherConfig struct {
+	Namespace     string        //metric namespace, default "tools"
+	Name          string        //value of "cacher" const label, tell cachers apart on one registry
+	Buckets       []float64     //latency buckets in seconds, default prometheus.DefBuckets
+	Logger        *zap.Logger   //slow operations are logged when set
+	SlowThreshold time.Duration //default 100ms
+}
+
+// InstrumentedCacher wrap any CacherV2 and count hits, misses, errors and latency
+// per operation. It is a prometheus.Collector, register it to expose the metrics:
+//
+//	c := NewInstrumentedCacher(NewCacherV2(rdb, "app", 60), InstrumentedCacherConfig{Name: "app"})
+//	prometheus.MustRegister(c)
+type InstrumentedCacher struct {
+	next    CacherV2
+	log     *zap.Logger
+	slow    time.Duration
+	ops     *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+}
+
+func NewInstrumentedCacher(next CacherV2, cfg InstrumentedCacherConfig) *InstrumentedCacher {
+	if cfg.Namespace == "" {
+		cfg.Namespace = "tools"
+	}
+	if len(cfg.Buckets) == 0 {
+		cfg.Buckets = prometheus.DefBuckets
+	}
+	if cfg.SlowThreshold <= 0 {
+		cfg.SlowThreshold = 100 * time.Millisecond
+	}
+
+	labels := prometheus.Labels{"cacher": cfg.Name}
+
+	return &InstrumentedCacher{
+		next: next,
+		log:  cfg.Logger,
+		slow: cfg.SlowThreshold,
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.Namespace,
+			Name:        "cache_operations_total",
+			Help:        "Number of cache operations by operation and result (hit, miss, ok, error).",
+			ConstLabels: labels,
+		}, []string{"op", "result"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   cfg.Namespace,
+			Name:        "cache_operation_duration_seconds",
+			Help:        "Latency of cache operations in seconds.",
+			ConstLabels: labels,
+			Buckets:     cfg.Buckets,
+		}, []string{"op"}),
+	}
+}
+
+func (c *InstrumentedCacher) Describe(ch chan<- *prometheus.Desc) {
+	c.ops.Describe(ch)
+	c.latency.Describe(ch)
+}
+
+func (c *InstrumentedCacher) Collect(ch chan<- prometheus.Metric) {
+	c.ops.Collect(ch)
+	c.latency.Collect(ch)
+}
+
+func cacheResult(err error, read bool) string {
+	switch {
+	case err == nil && read:
+		return cacheResultHit
+	case err == nil:
+		return cacheResultOK
+	case errors.Is(err, redis.Nil), errors.Is(err, ErrCacheMiss):
+		return cacheResultMiss
+	default:
+		return cacheResultError
+	}
+}
+
+// observe record latency and result of single operation
+func (c *InstrumentedCacher) observe(op, name string, start time.Time, err error, read bool) {
+	c.ops.WithLabelValues(op, cacheResult(err, read)).Inc()
+	c.observeLatency(op, name, start, err)
+}
+
+// observeBatch record latency once and result of every item
+func (c *InstrumentedCacher) observeBatch(op string, start time.Time, results []CacheResult, err error) {
+	if err != nil {
+		c.ops.WithLabelValues(op, cacheResultError).Inc()
+	}
+	for _, res := range results {
+		c.ops.WithLabelValues(op, cacheResult(res.Err, res.Op == CacheOpGet)).Inc()
+	}
+	c.observeLatency(op, "", start, err)
+}
+
+func (c *InstrumentedCacher) observeLatency(op, name string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	c.latency.WithLabelValues(op).Observe(elapsed.Seconds())
+
+	if c.log != nil && elapsed >= c.slow {
+		c.log.Warn("slow cache operation",
+			zap.String("op", op),
+			zap.String("key", name),
+			zap.Duration("elapsed", elapsed),
+			zap.Error(err),
+		)
+	}
+}
+
+func (c *InstrumentedCacher) Ping() error {
+	return c.PingCtx(ctxB)
+}
+
+func (c *InstrumentedCacher) Get(name string) (string, error) {
+	return c.GetCtx(ctxB, name)
+}
+
+func (c *InstrumentedCacher) Set(name string, value string) error {
+	return c.SetCtx(ctxB, name, value)
+}
+
+func (c *InstrumentedCacher) SetWithDuration(name string, value string, d time.Duration) error {
+	return c.SetWithDurationCtx(ctxB, name, value, d)
+}
+
+func (c *InstrumentedCacher) Delete(name string) error {
+	return c.DeleteCtx(ctxB, name)
+}
+
+func (c *InstrumentedCacher) GetKeysWithParam(name string) ([]string, error) {
+	return c.GetKeysWithParamCtx(ctxB, name)
+}
+
+func (c *InstrumentedCacher) PrintKeys() {
+	c.next.PrintKeys()
+}
+
+func (c *InstrumentedCacher) PingCtx(ctx context.Context) error {
+	start := time.Now()
+	err := c.next.PingCtx(ctx)
+	c.observe(CacheOpPing, "", start, err, false)
+	return err
+}
+
+func (c *InstrumentedCacher) GetCtx(ctx context.Context, name string) (string, error) {
+	start := time.Now()
+	val, err := c.next.GetCtx(ctx, name)
+	c.observe(CacheOpGet, name, start, err, true)
+	return val, err
+}
+
+func (c *InstrumentedCacher) SetCtx(ctx context.Context, name string, value string) error {
+	start := time.Now()
+	err := c.next.SetCtx(ctx, name, value)
+	c.observe(CacheOpSet, name, start, err, false)
+	return err
+}
+
+func (c *InstrumentedCacher) SetWithDurationCtx(ctx context.Context, name string, value string, d time.Duration) error {
+	start := time.Now()
+	err := c.next.SetWithDurationCtx(ctx, name, value, d)
+	c.observe(CacheOpSet, name, start, err, false)
+	return err
+}
+
+func (c *InstrumentedCacher) DeleteCtx(ctx context.Context, name string) error {
+	start := time.Now()
+	err := c.next.DeleteCtx(ctx, name)
+	c.observe(CacheOpDelete, name, start, err, false)
+	return err
+}
+
+func (c *InstrumentedCacher) GetKeysWithParamCtx(ctx context.Context, name string) ([]string, error) {
+	start := time.Now()
+	keys, err := c.next.GetKeysWithParamCtx(ctx, name)
+	c.observe(CacheOpKeys, name, start, err, false)
+	return keys, err
+}
+
+func (c *InstrumentedCacher) GetOrLoad(name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	return c.GetOrLoadCtx(ctxB, name, ttl, loader)
+}
+
+// GetOrLoadCtx count a hit when served from cache and a miss when loader was called
+func (c *InstrumentedCacher) GetOrLoadCtx(ctx context.Context, name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	start := time.Now()
+	loaded := false
+	val, err := c.next.GetOrLoadCtx(ctx, name, ttl, func() (string, error) {
+		loaded = true
+		return loader()
+	})
+
+	result := cacheResult(err, true)
+	if loaded && err == nil {
+		result = cacheResultMiss
+	}
+	c.ops.WithLabelValues(CacheOpLoad, result).Inc()
+	c.observeLatency(CacheOpLoad, name, start, err)
+
+	return val, err
+}
+
+func (c *InstrumentedCacher) ScanKeys(pattern string, batch int64) KeyIterator {
+	return c.ScanKeysCtx(ctxB, pattern, batch)
+}
+
+// ScanKeysCtx measure the whole iteration, recorded when iterator is exhausted
+func (c *InstrumentedCacher) ScanKeysCtx(ctx context.Context, pattern string, batch int64) KeyIterator {
+	return &instrumentedKeyIterator{
+		KeyIterator: c.next.ScanKeysCtx(ctx, pattern, batch),
+		c:           c,
+		pattern:     pattern,
+		start:       time.Now(),
+	}
+}
+
+func (c *InstrumentedCacher) DeleteByPattern(pattern string) (int64, error) {
+	return c.DeleteByPatternCtx(ctxB, pattern)
+}
+
+func (c *InstrumentedCacher) DeleteByPatternCtx(ctx context.Context, pattern string) (int64, error) {
+	start := time.Now()
+	n, err := c.next.DeleteByPatternCtx(ctx, pattern)
+	c.observe(CacheOpDeletePattern, pattern, start, err, false)
+	return n, err
+}
+
+func (c *InstrumentedCacher) MGet(names ...string) ([]CacheResult, error) {
+	return c.MGetCtx(ctxB, names...)
+}
+
+func (c *InstrumentedCacher) MGetCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	start := time.Now()
+	results, err := c.next.MGetCtx(ctx, names...)
+	c.observeBatch(CacheOpMGet, start, results, err)
+	return results, err
+}
+
+func (c *InstrumentedCacher) MSet(values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return c.MSetCtx(ctxB, values, ttl)
+}
+
+func (c *InstrumentedCacher) MSetCtx(ctx context.Context, values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	start := time.Now()
+	results, err := c.next.MSetCtx(ctx, values, ttl)
+	c.observeBatch(CacheOpMSet, start, results, err)
+	return results, err
+}
+
+func (c *InstrumentedCacher) DeleteMany(names ...string) ([]CacheResult, error) {
+	return c.DeleteManyCtx(ctxB, names...)
+}
+
+func (c *InstrumentedCacher) DeleteManyCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	start := time.Now()
+	results, err := c.next.DeleteManyCtx(ctx, names...)
+	c.observeBatch(CacheOpDeleteMany, start, results, err)
+	return results, err
+}
+
+func (c *InstrumentedCacher) Pipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.PipelineCtx(ctxB, fn)
+}
+
+func (c *InstrumentedCacher) PipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	start := time.Now()
+	results, err := c.next.PipelineCtx(ctx, fn)
+	c.observeBatch(CacheOpPipeline, start, results, err)
+	return results, err
+}
+
+func (c *InstrumentedCacher) TxPipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.TxPipelineCtx(ctxB, fn)
+}
+
+func (c *InstrumentedCacher) TxPipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	start := time.Now()
+	results, err := c.next.TxPipelineCtx(ctx, fn)
+	c.observeBatch(CacheOpTxPipeline, start, results, err)
+	return results, err
+}
+
+func (c *InstrumentedCacher) SetWithTags(name string, value string, ttl time.Duration, tags ...string) error {
+	return c.SetWithTagsCtx(ctxB, name, value, ttl, tags...)
+}
+
+func (c *InstrumentedCacher) SetWithTagsCtx(ctx context.Context, name string, value string, ttl time.Duration, tags ...string) error {
+	start := time.Now()
+	err := c.next.SetWithTagsCtx(ctx, name, value, ttl, tags...)
+	c.observe(CacheOpSetTags, name, start, err, false)
+	return err
+}
+
+func (c *InstrumentedCacher) InvalidateTag(tag string) (int64, error) {
+	return c.InvalidateTagCtx(ctxB, tag)
+}
+
+func (c *InstrumentedCacher) InvalidateTagCtx(ctx context.Context, tag string) (int64, error) {
+	start := time.Now()
+	n, err := c.next.InvalidateTagCtx(ctx, tag)
+	c.observe(CacheOpInvalidateTag, tag, start, err, false)
+	return n, err
+}
+
+type instrumentedKeyIterator struct {
+	KeyIterator
+	c        *InstrumentedCacher
+	pattern  string
+	start    time.Time
+	observed bool
+}
+
+func (i *instrumentedKeyIterator) Next() bool {
+	if i.KeyIterator.Next() {
+		return true
+	}
+
+	if !i.observed {
+		i.observed = true
+		i.c.observe(CacheOpScan, i.pattern, i.start, i.Err(), false)
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedCacher(t *testing.T) {
+	fake := NewFakeCacher("metrics", 60)
+	logger, logs := MockLogs()
+	c := NewInstrumentedCacher(fake, InstrumentedCacherConfig{
+		Name:          "test",
+		Logger:        logger,
+		SlowThreshold: time.Nanosecond,
+	})
+
+	reg := prometheus.NewRegistry()
+	assert.Nil(t, reg.Register(c), "should register as collector")
+
+	assert.Nil(t, c.Set("a", "1"))
+	_, err := c.Get("a")
+	assert.Nil(t, err)
+	_, err = c.Get("missing")
+	assert.NotNil(t, err)
+
+	broken := errors.New("broken")
+	fake.FailOnce(CacheOpGet, "a", broken)
+	_, err = c.Get("a")
+	assert.ErrorIs(t, err, broken)
+
+	_, err = c.MGet("a", "missing")
+	assert.Nil(t, err)
+
+	//loader call count as miss, cached value as hit
+	_, err = c.GetOrLoad("lazy", time.Minute, func() (string, error) { return "v", nil })
+	assert.Nil(t, err)
+	_, err = c.GetOrLoad("lazy", time.Minute, func() (string, error) { return "v", nil })
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpSet, "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpGet, "hit")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpGet, "miss")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpGet, "error")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpMGet, "hit")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpMGet, "miss")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpLoad, "miss")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.ops.WithLabelValues(CacheOpLoad, "hit")))
+
+	//histogram is exposed per operation
+	count, err := testutil.GatherAndCount(reg, "tools_cache_operation_duration_seconds")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count, "should have histogram per operation")
+
+	//every operation is slower than 1ns
+	assert.Greater(t, logs.FilterMessage("slow cache operation").Len(), 0, "should log slow operations")
+}
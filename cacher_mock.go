@@ -1,6 +1,11 @@
 package tools
 
 import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 )
@@ -20,3 +25,271 @@ func MockRedis() (*redis.Client, error) {
 
 	return client, nil
 }
+
+type CacherCall struct {
+	Op    string
+	Name  string
+	Value string
+}
+
+// FakeCacher is in memory CacherV2 that record every call and can be scripted
+// to fail on given operation and key, use it in unit test instead of Cacher hooks
+type FakeCacher struct {
+	mem   *MemoryCacher
+	mu    sync.Mutex
+	calls []CacherCall
+	fails map[string]fakeFailure
+}
+
+type fakeFailure struct {
+	err  error
+	once bool
+}
+
+func NewFakeCacher(prefix string, expiracy int) *FakeCacher {
+	return &FakeCacher{
+		mem:   NewMemoryCacher(prefix, expiracy, 0),
+		fails: make(map[string]fakeFailure),
+	}
+}
+
+// FailOn make op on name return err until ClearFailures, empty name match any key
+func (f *FakeCacher) FailOn(op, name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fails[op+"|"+name] = fakeFailure{err: err}
+}
+
+// FailOnce make next op on name return err, empty name match any key
+func (f *FakeCacher) FailOnce(op, name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fails[op+"|"+name] = fakeFailure{err: err, once: true}
+}
+
+func (f *FakeCacher) ClearFailures() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fails = make(map[string]fakeFailure)
+}
+
+// Calls return recorded calls in order
+func (f *FakeCacher) Calls() []CacherCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]CacherCall{}, f.calls...)
+}
+
+// CallCount return number of calls of op
+func (f *FakeCacher) CallCount(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, call := range f.calls {
+		if call.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *FakeCacher) record(op, name, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, CacherCall{Op: op, Name: name, Value: value})
+
+	for _, key := range []string{op + "|" + name, op + "|"} {
+		if fail, ok := f.fails[key]; ok {
+			if fail.once {
+				delete(f.fails, key)
+			}
+			return fail.err
+		}
+	}
+
+	return nil
+}
+
+func (f *FakeCacher) Ping() error {
+	return f.PingCtx(ctxB)
+}
+
+func (f *FakeCacher) Get(name string) (string, error) {
+	return f.GetCtx(ctxB, name)
+}
+
+func (f *FakeCacher) Set(name string, value string) error {
+	return f.SetCtx(ctxB, name, value)
+}
+
+func (f *FakeCacher) SetWithDuration(name string, value string, d time.Duration) error {
+	return f.SetWithDurationCtx(ctxB, name, value, d)
+}
+
+func (f *FakeCacher) Delete(name string) error {
+	return f.DeleteCtx(ctxB, name)
+}
+
+func (f *FakeCacher) GetKeysWithParam(name string) ([]string, error) {
+	return f.GetKeysWithParamCtx(ctxB, name)
+}
+
+func (f *FakeCacher) PrintKeys() {
+	f.mem.PrintKeys()
+}
+
+func (f *FakeCacher) PingCtx(ctx context.Context) error {
+	if err := f.record(CacheOpPing, "", ""); err != nil {
+		return err
+	}
+	return f.mem.PingCtx(ctx)
+}
+
+func (f *FakeCacher) GetCtx(ctx context.Context, name string) (string, error) {
+	if err := f.record(CacheOpGet, name, ""); err != nil {
+		return "", err
+	}
+	return f.mem.GetCtx(ctx, name)
+}
+
+func (f *FakeCacher) SetCtx(ctx context.Context, name string, value string) error {
+	if err := f.record(CacheOpSet, name, value); err != nil {
+		return err
+	}
+	return f.mem.SetCtx(ctx, name, value)
+}
+
+func (f *FakeCacher) SetWithDurationCtx(ctx context.Context, name string, value string, d time.Duration) error {
+	if err := f.record(CacheOpSet, name, value); err != nil {
+		return err
+	}
+	return f.mem.SetWithDurationCtx(ctx, name, value, d)
+}
+
+func (f *FakeCacher) DeleteCtx(ctx context.Context, name string) error {
+	if err := f.record(CacheOpDelete, name, ""); err != nil {
+		return err
+	}
+	return f.mem.DeleteCtx(ctx, name)
+}
+
+func (f *FakeCacher) GetKeysWithParamCtx(ctx context.Context, name string) ([]string, error) {
+	if err := f.record(CacheOpKeys, name, ""); err != nil {
+		return nil, err
+	}
+	return f.mem.GetKeysWithParamCtx(ctx, name)
+}
+
+func (f *FakeCacher) GetOrLoad(name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	return f.GetOrLoadCtx(ctxB, name, ttl, loader)
+}
+
+func (f *FakeCacher) GetOrLoadCtx(ctx context.Context, name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	if err := f.record(CacheOpLoad, name, ""); err != nil {
+		return "", err
+	}
+	return f.mem.GetOrLoadCtx(ctx, name, ttl, loader)
+}
+
+func (f *FakeCacher) ScanKeys(pattern string, batch int64) KeyIterator {
+	return f.ScanKeysCtx(ctxB, pattern, batch)
+}
+
+func (f *FakeCacher) ScanKeysCtx(ctx context.Context, pattern string, batch int64) KeyIterator {
+	if err := f.record(CacheOpScan, pattern, ""); err != nil {
+		return &sliceKeyIterator{pos: -1, err: err}
+	}
+	return f.mem.ScanKeysCtx(ctx, pattern, batch)
+}
+
+func (f *FakeCacher) DeleteByPattern(pattern string) (int64, error) {
+	return f.DeleteByPatternCtx(ctxB, pattern)
+}
+
+func (f *FakeCacher) DeleteByPatternCtx(ctx context.Context, pattern string) (int64, error) {
+	if err := f.record(CacheOpDeletePattern, pattern, ""); err != nil {
+		return 0, err
+	}
+	return f.mem.DeleteByPatternCtx(ctx, pattern)
+}
+
+// batch calls are recorded once with names joined by comma
+
+func (f *FakeCacher) MGet(names ...string) ([]CacheResult, error) {
+	return f.MGetCtx(ctxB, names...)
+}
+
+func (f *FakeCacher) MGetCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	if err := f.record(CacheOpMGet, strings.Join(names, ","), ""); err != nil {
+		return nil, err
+	}
+	return f.mem.MGetCtx(ctx, names...)
+}
+
+func (f *FakeCacher) MSet(values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return f.MSetCtx(ctxB, values, ttl)
+}
+
+func (f *FakeCacher) MSetCtx(ctx context.Context, values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	if err := f.record(CacheOpMSet, strings.Join(sortedNames(values), ","), ""); err != nil {
+		return nil, err
+	}
+	return f.mem.MSetCtx(ctx, values, ttl)
+}
+
+func (f *FakeCacher) DeleteMany(names ...string) ([]CacheResult, error) {
+	return f.DeleteManyCtx(ctxB, names...)
+}
+
+func (f *FakeCacher) DeleteManyCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	if err := f.record(CacheOpDeleteMany, strings.Join(names, ","), ""); err != nil {
+		return nil, err
+	}
+	return f.mem.DeleteManyCtx(ctx, names...)
+}
+
+func (f *FakeCacher) Pipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return f.PipelineCtx(ctxB, fn)
+}
+
+func (f *FakeCacher) PipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	if err := f.record(CacheOpPipeline, "", ""); err != nil {
+		return nil, err
+	}
+	return f.mem.PipelineCtx(ctx, fn)
+}
+
+func (f *FakeCacher) TxPipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return f.TxPipelineCtx(ctxB, fn)
+}
+
+func (f *FakeCacher) TxPipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	if err := f.record(CacheOpTxPipeline, "", ""); err != nil {
+		return nil, err
+	}
+	return f.mem.TxPipelineCtx(ctx, fn)
+}
+
+func (f *FakeCacher) SetWithTags(name string, value string, ttl time.Duration, tags ...string) error {
+	return f.SetWithTagsCtx(ctxB, name, value, ttl, tags...)
+}
+
+func (f *FakeCacher) SetWithTagsCtx(ctx context.Context, name string, value string, ttl time.Duration, tags ...string) error {
+	if err := f.record(CacheOpSetTags, name, value); err != nil {
+		return err
+	}
+	return f.mem.SetWithTagsCtx(ctx, name, value, ttl, tags...)
+}
+
+func (f *FakeCacher) InvalidateTag(tag string) (int64, error) {
+	return f.InvalidateTagCtx(ctxB, tag)
+}
+
+func (f *FakeCacher) InvalidateTagCtx(ctx context.Context, tag string) (int64, error) {
+	if err := f.record(CacheOpInvalidateTag, tag, ""); err != nil {
+		return 0, err
+	}
+	return f.mem.InvalidateTagCtx(ctx, tag)
+}
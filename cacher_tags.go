@@ -0,0 +1,111 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// set the value and add the key to every tag set. Tag set live as long as its
+// longest member, and a few dead members are sampled out on every write.
+var setWithTagsScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+
+for i = 2, #KEYS do
+	local existed = redis.call("EXISTS", KEYS[i])
+	redis.call("SADD", KEYS[i], KEYS[1])
+
+	if ttl == 0 then
+		redis.call("PERSIST", KEYS[i])
+	elseif existed == 0 then
+		redis.call("PEXPIRE", KEYS[i], ttl)
+	else
+		local current = redis.call("PTTL", KEYS[i])
+		if current >= 0 and current < ttl then
+			redis.call("PEXPIRE", KEYS[i], ttl)
+		end
+	end
+
+	local sample = redis.call("SRANDMEMBER", KEYS[i], 10)
+	for _, member in ipairs(sample) do
+		if redis.call("EXISTS", member) == 0 then
+			redis.call("SREM", KEYS[i], member)
+		end
+	end
+end
+return 1
+`)
+
+// delete every member of the tag and the tag itself, return deleted keys
+var invalidateTagScript = redis.NewScript(`
+local members = redis.call("SMEMBERS", KEYS[1])
+local deleted = {}
+for _, key in ipairs(members) do
+	if redis.call("DEL", key) == 1 then
+		table.insert(deleted, key)
+	end
+end
+redis.call("DEL", KEYS[1])
+return deleted
+`)
+
+// tagKey live beside the prefix rather than under it, so no c.key(name) can
+// collide with a tag set and prefix_* scans never see them
+func (c *cacher) tagKey(tag string) string {
+	return c.prefix + ":tag:" + tag
+}
+
+func (c *cacher) SetWithTags(name string, value string, ttl time.Duration, tags ...string) error {
+	return c.SetWithTagsCtx(ctxB, name, value, ttl, tags...)
+}
+
+// SetWithTagsCtx store value and register it under every tag, ttl 0 use cacher expiracy.
+// On cluster, prefix must contain a hash tag such as "{app}" so key and tag sets share a slot.
+func (c *cacher) SetWithTagsCtx(ctx context.Context, name string, value string, ttl time.Duration, tags ...string) error {
+	if ttl <= 0 {
+		ttl = c.expiracy
+	}
+
+	keys := []string{c.key(name)}
+	for _, tag := range tags {
+		keys = append(keys, c.tagKey(tag))
+	}
+
+	if err := setWithTagsScript.Run(ctx, c.rdb, keys, value, ttl.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("fail set tagged cache %s : %w", name, err)
+	}
+
+	return nil
+}
+
+func (c *cacher) InvalidateTag(tag string) (int64, error) {
+	return c.InvalidateTagCtx(ctxB, tag)
+}
+
+func (c *cacher) InvalidateTagCtx(ctx context.Context, tag string) (int64, error) {
+	names, err := c.invalidateTag(ctx, tag)
+	return int64(len(names)), err
+}
+
+// invalidateTag return names of deleted keys without prefix
+func (c *cacher) invalidateTag(ctx context.Context, tag string) ([]string, error) {
+	keys, err := invalidateTagScript.Run(ctx, c.rdb, []string{c.tagKey(tag)}).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("fail invalidate tag %s : %w", tag, err)
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, c.prefix+"_"))
+	}
+
+	return names, nil
+}
@@ -0,0 +1,113 @@
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacherTags(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	c := NewCacherV2(rds, "tags", 60)
+
+	assert.Nil(t, c.SetWithTags("product_1", "a", time.Minute, "products", "tenant_42"))
+	assert.Nil(t, c.SetWithTags("product_2", "b", time.Hour, "products"))
+	assert.Nil(t, c.SetWithTags("invoice_1", "c", time.Minute, "tenant_42"))
+
+	//tag set live as long as its longest member
+	assert.Equal(t, time.Hour, mr.TTL("tags:tag:products"), "tag should outlive members")
+
+	deleted, err := c.InvalidateTag("tenant_42")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(2), deleted, "should delete tagged keys")
+	assert.False(t, mr.Exists("tags:tag:tenant_42"), "tag set should be removed")
+
+	_, err = c.Get("invoice_1")
+	assert.ErrorIs(t, err, redis.Nil, "tagged key should be gone")
+	val, err := c.Get("product_2")
+	assert.Nil(t, err, "untagged key should stay")
+	assert.Equal(t, "b", val)
+
+	//expired members are dropped from tag set on next write
+	assert.Nil(t, c.SetWithTags("product_3", "d", time.Second, "short"))
+	mr.FastForward(2 * time.Second)
+	assert.False(t, mr.Exists("tags:tag:short"), "tag set should expire with members")
+
+	assert.Nil(t, c.SetWithTags("product_4", "e", time.Second, "products"))
+	mr.FastForward(2 * time.Second)
+	assert.Nil(t, c.SetWithTags("product_5", "f", time.Hour, "products"))
+	members, err := rds.SMembers(context.Background(), "tags:tag:products").Result()
+	assert.Nil(t, err)
+	assert.NotContains(t, members, "tags_product_4", "expired member should be pruned")
+
+	//tag sets are out of user key space and scans
+	keys, err := c.GetKeysWithParam("*")
+	assert.Nil(t, err, "should nil")
+	assert.ElementsMatch(t, []string{"tags_product_2", "tags_product_5"}, keys, "should not list tag sets")
+	assert.Nil(t, c.Set("tag_products", "user"))
+	assert.True(t, mr.Exists("tags:tag:products"), "user key should not overwrite tag set")
+
+	deleted, err = c.InvalidateTag("products")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(2), deleted, "only live members are counted")
+	val, err = c.Get("tag_products")
+	assert.Nil(t, err, "user key should stay")
+	assert.Equal(t, "user", val)
+
+	//pattern delete leave tag sets alone
+	assert.Nil(t, c.SetWithTags("product_6", "g", time.Hour, "products"))
+	_, err = c.DeleteByPattern("*")
+	assert.Nil(t, err, "should nil")
+	assert.True(t, mr.Exists("tags:tag:products"), "tag set should not be scanned")
+}
+
+func TestMemoryCacherTags(t *testing.T) {
+	c := NewMemoryCacher("tags", 60, 0)
+	defer c.Close()
+
+	assert.Nil(t, c.SetWithTags("a", "1", time.Minute, "group"))
+	assert.Nil(t, c.SetWithTags("b", "2", time.Minute, "group", "other"))
+
+	deleted, err := c.InvalidateTag("group")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(2), deleted, "should delete tagged keys")
+
+	_, err = c.Get("b")
+	assert.ErrorIs(t, err, redis.Nil, "tagged key should be gone")
+
+	deleted, err = c.InvalidateTag("other")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(0), deleted, "already deleted key is not counted")
+}
+
+func TestTieredCacherTags(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	a, err := NewTieredCacher(rds, "tier", 60, TieredCacheConfig{})
+	assert.Nil(t, err)
+	defer a.Close()
+	b, err := NewTieredCacher(rds, "tier", 60, TieredCacheConfig{})
+	assert.Nil(t, err)
+	defer b.Close()
+
+	assert.Nil(t, a.SetWithTags("page_1", "html", 0, "pages"))
+	_, err = b.Get("page_1")
+	assert.Nil(t, err, "b should cache locally")
+
+	deleted, err := a.InvalidateTag("pages")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(1), deleted)
+	assert.Eventually(t, func() bool {
+		_, err := b.Get("page_1")
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "b should drop local copy")
+}
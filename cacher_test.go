@@ -2,10 +2,12 @@ package tools
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,3 +60,179 @@ func TestMockRedis(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "test", value)
 }
+
+func TestCacherV2Ctx(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	c := NewCacherV2(rds, "ctx", 60)
+	ctx := context.Background()
+
+	err = c.SetCtx(ctx, "test", "value")
+	assert.Nil(t, err, "should nil")
+
+	val, err := c.GetCtx(ctx, "test")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "value", val, "should have value")
+
+	//old method should read the same key
+	val, err = c.Get("test")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "value", val, "should have value")
+
+	keys, err := c.GetKeysWithParamCtx(ctx, "te*")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, []string{"ctx_test"}, keys, "should have value")
+
+	//canceled context must not reach redis
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.GetCtx(canceled, "test")
+	assert.ErrorIs(t, err, context.Canceled, "should return context error")
+
+	err = c.DeleteCtx(ctx, "test")
+	assert.Nil(t, err, "should nil")
+
+	_, err = c.GetCtx(ctx, "test")
+	assert.ErrorIs(t, err, redis.Nil, "should be deleted")
+
+	assert.Nil(t, c.PingCtx(ctx), "should nil")
+}
+
+func TestCacherV2ScanKeys(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	c := NewCacherV2(rds, "scan", 60)
+	other := NewCacherV2(rds, "other", 60)
+
+	for i := 0; i < 250; i++ {
+		err = c.Set("user_"+IntToString(i), "value")
+		assert.Nil(t, err, "should nil")
+	}
+	err = c.Set("config", "value")
+	assert.Nil(t, err, "should nil")
+	err = other.Set("user_1", "value")
+	assert.Nil(t, err, "should nil")
+
+	//iterator only see keys under cacher prefix
+	count := 0
+	it := c.ScanKeys("user_*", 50)
+	for it.Next() {
+		assert.Contains(t, it.Key(), "scan_user_", "key should be prefixed")
+		count++
+	}
+	assert.Nil(t, it.Err(), "should nil")
+	assert.Equal(t, 250, count, "should iterate all matching keys")
+
+	keys, err := c.GetKeysWithParam("conf*")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, []string{"scan_config"}, keys, "should have value")
+
+	//delete by pattern spans multiple batches. miniredis SCAN cursor is an offset
+	//that shift on delete, unlike redis, so run it until nothing is left
+	var deleted int64
+	for {
+		n, err := c.DeleteByPattern("user_*")
+		assert.Nil(t, err, "should nil")
+		if n == 0 {
+			break
+		}
+		deleted += n
+	}
+	assert.Equal(t, int64(250), deleted, "should delete all matching keys")
+
+	keys, err = c.GetKeysWithParam("*")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, []string{"scan_config"}, keys, "only unmatched key should remain")
+
+	//other prefix is untouched
+	val, err := other.Get("user_1")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "value", val, "should have value")
+}
+
+func TestCacherV2DeleteByPatternBusyWriter(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+	c := NewCacherV2(rds, "busy", 60)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Set("user_"+IntToString(i), "value")
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.DeleteByPattern("user_*")
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		assert.Nil(t, err, "should nil")
+	case <-time.After(5 * time.Second):
+		t.Fatal("delete by pattern did not return while writer is busy")
+	}
+}
+
+func TestNewUniversalRedisClient(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+	addr := rds.Options().Addr
+
+	//single node from host and port
+	host, port, _ := strings.Cut(addr, ":")
+	client, err := NewUniversalRedisClient(RedisConfiguration{Host: host, Port: port, PoolSize: 5})
+	assert.Nil(t, err, "should nil")
+	assert.IsType(t, &redis.Client{}, client, "should be single node client")
+	assert.Nil(t, client.Ping(context.Background()).Err(), "should connect")
+
+	//universal client is accepted by cacher
+	c := NewCacherV2(client, "universal", 60)
+	assert.Nil(t, c.Set("test", "value"), "should nil")
+
+	cluster, err := NewUniversalRedisClient(RedisConfiguration{Addrs: []string{"10.0.0.1:6379", "10.0.0.2:6379"}})
+	assert.Nil(t, err, "should nil")
+	assert.IsType(t, &redis.ClusterClient{}, cluster, "should be cluster client")
+
+	sentinel, err := NewUniversalRedisClient(RedisConfiguration{MasterName: "mymaster", Addrs: []string{"10.0.0.1:26379"}})
+	assert.Nil(t, err, "should nil")
+	assert.IsType(t, &redis.Client{}, sentinel, "should be failover client")
+
+	_, err = NewUniversalRedisClient(RedisConfiguration{Mode: RedisModeSentinel, Addrs: []string{"10.0.0.1:26379"}})
+	assert.NotNil(t, err, "master name should be required")
+
+	_, err = NewUniversalRedisClient(RedisConfiguration{Host: host, Port: port, TLS: true, TLSCACert: "/not/exist.pem"})
+	assert.NotNil(t, err, "missing ca cert should fail")
+
+	seed, err := NewUniversalRedisClient(RedisConfiguration{Mode: "Cluster", Addrs: []string{"10.0.0.1:6379"}})
+	assert.Nil(t, err, "should nil")
+	assert.IsType(t, &redis.ClusterClient{}, seed, "should honor explicit cluster mode with one seed")
+
+	_, err = NewStatsQueue(RedisConfiguration{Addrs: []string{"10.0.0.1:6379", "10.0.0.2:6379"}})
+	assert.NotNil(t, err, "stats queue should reject cluster")
+
+	_, err = NewStatsQueue(RedisConfiguration{Mode: RedisModeCluster, Addrs: []string{"10.0.0.1:6379"}})
+	assert.NotNil(t, err, "stats queue should reject single seed cluster")
+
+	_, err = NewStatsQueue(RedisConfiguration{Host: host, Port: port, TLS: true})
+	assert.NotNil(t, err, "stats queue should reject tls")
+
+	qHost, qPort, err := statsQueueAddr(RedisConfiguration{Addrs: []string{addr}})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, addr, qHost+":"+qPort, "stats queue should dial first address")
+}
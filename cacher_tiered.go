@@ -0,0 +1,529 @@
+package tools
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type TieredCacheConfig struct {
+	Size     int           //max entries kept in memory, default 1000
+	LocalTTL time.Duration //max age of in memory copy, default 1 minute
+	Channel  string        //pub/sub channel for invalidation, default prefix + "_invalidate"
+}
+
+type TieredCacheStats struct {
+	LocalHits    uint64
+	LocalMisses  uint64
+	RemoteHits   uint64
+	RemoteMisses uint64
+	RemoteErrors uint64 //failed remote reads other than missing key
+
+	// failed invalidation publish, the write itself succeeded and
+	// other replicas keep their stale copy for at most LocalTTL
+	InvalidationErrors uint64
+}
+
+// TieredCacher keep bounded in memory copy in front of redis cacher,
+// Set and Delete evict the local copy on other replicas through redis pub/sub.
+// A local copy is never older than LocalTTL even if an invalidation is missed.
+// Failed invalidation publish doesn't fail the write, it is counted in Stats.
+type TieredCacher struct {
+	remote  *cacher
+	rdb     redis.UniversalClient
+	local   *localCache
+	channel string
+	id      string
+	pubsub  *redis.PubSub
+
+	localHits    atomic.Uint64
+	localMisses  atomic.Uint64
+	remoteHits   atomic.Uint64
+	remoteMisses atomic.Uint64
+	remoteErrors atomic.Uint64
+	pubErrors    atomic.Uint64
+}
+
+// invalidation message is "<instance id>|<op>|<name or pattern>"
+const (
+	invalidateKey     = "k"
+	invalidatePattern = "p"
+)
+
+func NewTieredCacher(rdb redis.UniversalClient, prefix string, expiracy int, cfg TieredCacheConfig, opts ...CacherOption) (*TieredCacher, error) {
+	if cfg.Size <= 0 {
+		cfg.Size = 1000
+	}
+	if cfg.LocalTTL <= 0 {
+		cfg.LocalTTL = time.Minute
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = prefix + "_invalidate"
+	}
+
+	c := &TieredCacher{
+		remote:  NewCacherV2(rdb, prefix, expiracy, opts...).(*cacher),
+		rdb:     rdb,
+		local:   newLocalCache(cfg.Size, cfg.LocalTTL),
+		channel: cfg.Channel,
+		id:      ShortUUID(),
+	}
+
+	c.pubsub = rdb.Subscribe(ctxB, c.channel)
+	//wait for subscription confirmation so no invalidation is missed after return
+	if _, err := c.pubsub.Receive(ctxB); err != nil {
+		c.pubsub.Close()
+		return nil, fmt.Errorf("fail subscribe invalidation channel : %w", err)
+	}
+
+	go c.listen()
+
+	return c, nil
+}
+
+func (c *TieredCacher) listen() {
+	for msg := range c.pubsub.Channel() {
+		parts := strings.SplitN(msg.Payload, "|", 3)
+		if len(parts) != 3 || parts[0] == c.id {
+			continue
+		}
+
+		switch parts[1] {
+		case invalidateKey:
+			c.local.remove(parts[2])
+		case invalidatePattern:
+			c.local.removeMatch(parts[2])
+		}
+	}
+}
+
+// publish tell other replicas to drop their local copy, failure is only counted
+// because the remote write already succeeded and local copies expire by LocalTTL
+func (c *TieredCacher) publish(ctx context.Context, op, name string) {
+	if err := c.rdb.Publish(ctx, c.channel, c.id+"|"+op+"|"+name).Err(); err != nil {
+		c.pubErrors.Add(1)
+	}
+}
+
+// Stats return hit and miss counters of both tiers
+func (c *TieredCacher) Stats() TieredCacheStats {
+	return TieredCacheStats{
+		LocalHits:    c.localHits.Load(),
+		LocalMisses:  c.localMisses.Load(),
+		RemoteHits:   c.remoteHits.Load(),
+		RemoteMisses: c.remoteMisses.Load(),
+		RemoteErrors: c.remoteErrors.Load(),
+
+		InvalidationErrors: c.pubErrors.Load(),
+	}
+}
+
+// Close stop listening for invalidation
+func (c *TieredCacher) Close() error {
+	return c.pubsub.Close()
+}
+
+func (c *TieredCacher) Ping() error {
+	return c.PingCtx(ctxB)
+}
+
+func (c *TieredCacher) Get(name string) (string, error) {
+	return c.GetCtx(ctxB, name)
+}
+
+func (c *TieredCacher) Set(name string, value string) error {
+	return c.SetCtx(ctxB, name, value)
+}
+
+func (c *TieredCacher) SetWithDuration(name string, value string, d time.Duration) error {
+	return c.SetWithDurationCtx(ctxB, name, value, d)
+}
+
+func (c *TieredCacher) Delete(name string) error {
+	return c.DeleteCtx(ctxB, name)
+}
+
+func (c *TieredCacher) GetKeysWithParam(name string) ([]string, error) {
+	return c.GetKeysWithParamCtx(ctxB, name)
+}
+
+func (c *TieredCacher) PrintKeys() {
+	c.remote.PrintKeys()
+}
+
+func (c *TieredCacher) PingCtx(ctx context.Context) error {
+	return c.remote.PingCtx(ctx)
+}
+
+func (c *TieredCacher) GetCtx(ctx context.Context, name string) (string, error) {
+	if val, ok := c.local.get(name); ok {
+		c.localHits.Add(1)
+		return val, nil
+	}
+	c.localMisses.Add(1)
+
+	results, err := c.fetch(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if errors.Is(results[0].Err, ErrCacheMiss) {
+		return "", redis.Nil
+	}
+	return results[0].Value, results[0].Err
+}
+
+func (c *TieredCacher) SetCtx(ctx context.Context, name string, value string) error {
+	if err := c.remote.SetCtx(ctx, name, value); err != nil {
+		return err
+	}
+
+	c.local.set(name, value, c.remote.expiracy)
+	c.publish(ctx, invalidateKey, name)
+	return nil
+}
+
+func (c *TieredCacher) SetWithDurationCtx(ctx context.Context, name string, value string, d time.Duration) error {
+	if err := c.remote.SetWithDurationCtx(ctx, name, value, d); err != nil {
+		return err
+	}
+
+	c.local.set(name, value, d)
+	c.publish(ctx, invalidateKey, name)
+	return nil
+}
+
+func (c *TieredCacher) DeleteCtx(ctx context.Context, name string) error {
+	c.local.remove(name)
+	if err := c.remote.DeleteCtx(ctx, name); err != nil {
+		return err
+	}
+
+	c.publish(ctx, invalidateKey, name)
+	return nil
+}
+
+func (c *TieredCacher) GetKeysWithParamCtx(ctx context.Context, name string) ([]string, error) {
+	return c.remote.GetKeysWithParamCtx(ctx, name)
+}
+
+func (c *TieredCacher) GetOrLoad(name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	return c.GetOrLoadCtx(ctxB, name, ttl, loader)
+}
+
+func (c *TieredCacher) GetOrLoadCtx(ctx context.Context, name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	if val, ok := c.local.get(name); ok {
+		c.localHits.Add(1)
+		return val, nil
+	}
+	c.localMisses.Add(1)
+
+	if results, err := c.fetch(ctx, name); err == nil && results[0].Err == nil {
+		return results[0].Value, nil
+	}
+
+	//miss or read error, remote share loading across callers
+	if ttl <= 0 {
+		ttl = c.remote.expiracy
+	}
+	val, err := c.remote.GetOrLoadCtx(ctx, name, ttl, loader)
+	if err != nil {
+		return val, err
+	}
+
+	c.local.set(name, val, ttl)
+	return val, nil
+}
+
+// fetch read names with their remaining ttl in one round-trip, so hits are kept
+// in memory no longer than the redis key live
+func (c *TieredCacher) fetch(ctx context.Context, names ...string) ([]CacheResult, error) {
+	p := c.rdb.Pipeline()
+	gets := make([]*redis.StringCmd, len(names))
+	ttls := make([]*redis.DurationCmd, len(names))
+	for i, name := range names {
+		gets[i] = p.Get(ctx, c.remote.key(name))
+		ttls[i] = p.PTTL(ctx, c.remote.key(name))
+	}
+
+	//exec error is only the first failed command, every command carry its own
+	_, err := p.Exec(ctx)
+
+	results := make([]CacheResult, len(names))
+	failed := 0
+	for i, name := range names {
+		results[i] = redisBatchResult(CacheResult{Op: CacheOpGet, Name: name}, gets[i])
+
+		switch {
+		case errors.Is(results[i].Err, ErrCacheMiss):
+			c.remoteMisses.Add(1)
+		case results[i].Err != nil:
+			c.remoteErrors.Add(1)
+			failed++
+		default:
+			c.remoteHits.Add(1)
+			//-1 is a key without expiry, -2 a key gone since the GET
+			if ttl, terr := ttls[i].Result(); terr == nil && ttl != -2 {
+				c.local.set(name, results[i].Value, ttl)
+			}
+		}
+	}
+
+	//the round-trip itself failed
+	if err != nil && failed == len(names) {
+		return results, err
+	}
+	return results, nil
+}
+
+func (c *TieredCacher) ScanKeys(pattern string, batch int64) KeyIterator {
+	return c.remote.ScanKeys(pattern, batch)
+}
+
+func (c *TieredCacher) ScanKeysCtx(ctx context.Context, pattern string, batch int64) KeyIterator {
+	return c.remote.ScanKeysCtx(ctx, pattern, batch)
+}
+
+func (c *TieredCacher) DeleteByPattern(pattern string) (int64, error) {
+	return c.DeleteByPatternCtx(ctxB, pattern)
+}
+
+func (c *TieredCacher) DeleteByPatternCtx(ctx context.Context, pattern string) (int64, error) {
+	c.local.removeMatch(pattern)
+	deleted, err := c.remote.DeleteByPatternCtx(ctx, pattern)
+	if err != nil {
+		return deleted, err
+	}
+
+	c.publish(ctx, invalidatePattern, pattern)
+	return deleted, nil
+}
+
+// localCache is LRU bounded by size where every entry also expire by ttl
+type localCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type localEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+func newLocalCache(size int, ttl time.Duration) *localCache {
+	return &localCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *localCache) get(key string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return "", false
+	}
+
+	entry := el.Value.(*localEntry)
+	if time.Now().After(entry.expires) {
+		l.ll.Remove(el)
+		delete(l.items, key)
+		return "", false
+	}
+
+	l.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+// set store value for at most local ttl, shorter positive ttl is used when given
+func (l *localCache) set(key, value string, ttl time.Duration) {
+	if ttl <= 0 || ttl > l.ttl {
+		ttl = l.ttl
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		entry := el.Value.(*localEntry)
+		entry.value = value
+		entry.expires = time.Now().Add(ttl)
+		l.ll.MoveToFront(el)
+		return
+	}
+
+	l.items[key] = l.ll.PushFront(&localEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+	for l.ll.Len() > l.size {
+		oldest := l.ll.Back()
+		l.ll.Remove(oldest)
+		delete(l.items, oldest.Value.(*localEntry).key)
+	}
+}
+
+func (l *localCache) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.ll.Remove(el)
+		delete(l.items, key)
+	}
+}
+
+func (l *localCache) removeMatch(pattern string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, el := range l.items {
+		if matchGlob(pattern, key) {
+			l.ll.Remove(el)
+			delete(l.items, key)
+		}
+	}
+}
+
+func (c *TieredCacher) MGet(names ...string) ([]CacheResult, error) {
+	return c.MGetCtx(ctxB, names...)
+}
+
+// MGetCtx serve what it can from memory and fetch the rest in one round-trip
+func (c *TieredCacher) MGetCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	results := make([]CacheResult, len(names))
+	missing := []string{}
+	missingIdx := []int{}
+
+	for i, name := range names {
+		results[i] = CacheResult{Op: CacheOpGet, Name: name}
+		if val, ok := c.local.get(name); ok {
+			c.localHits.Add(1)
+			results[i].Value = val
+			continue
+		}
+		c.localMisses.Add(1)
+		missing = append(missing, name)
+		missingIdx = append(missingIdx, i)
+	}
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	remote, err := c.fetch(ctx, missing...)
+	for i, res := range remote {
+		results[missingIdx[i]] = res
+	}
+
+	return results, err
+}
+
+func (c *TieredCacher) MSet(values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return c.MSetCtx(ctxB, values, ttl)
+}
+
+func (c *TieredCacher) MSetCtx(ctx context.Context, values map[string]string, ttl time.Duration) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, msetBatch(values, ttl))
+}
+
+func (c *TieredCacher) DeleteMany(names ...string) ([]CacheResult, error) {
+	return c.DeleteManyCtx(ctxB, names...)
+}
+
+func (c *TieredCacher) DeleteManyCtx(ctx context.Context, names ...string) ([]CacheResult, error) {
+	return c.PipelineCtx(ctx, deleteBatch(names))
+}
+
+func (c *TieredCacher) Pipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.PipelineCtx(ctxB, fn)
+}
+
+func (c *TieredCacher) PipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	results, err := c.remote.PipelineCtx(ctx, fn)
+	return results, c.invalidateBatch(ctx, results, err)
+}
+
+func (c *TieredCacher) TxPipeline(fn func(b CacheBatch)) ([]CacheResult, error) {
+	return c.TxPipelineCtx(ctxB, fn)
+}
+
+func (c *TieredCacher) TxPipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error) {
+	results, err := c.remote.TxPipelineCtx(ctx, fn)
+	return results, c.invalidateBatch(ctx, results, err)
+}
+
+// drop local copy of every written name and tell other replicas in one round-trip,
+// failed publish is counted like publish
+func (c *TieredCacher) invalidateBatch(ctx context.Context, results []CacheResult, err error) error {
+	names := []string{}
+	for _, res := range results {
+		if res.Op == CacheOpSet || res.Op == CacheOpDelete {
+			c.local.remove(res.Name)
+			names = append(names, res.Name)
+		}
+	}
+
+	if err != nil || len(names) == 0 {
+		return err
+	}
+
+	_, perr := c.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, name := range names {
+			p.Publish(ctx, c.channel, c.id+"|"+invalidateKey+"|"+name)
+		}
+		return nil
+	})
+	if perr != nil {
+		c.pubErrors.Add(1)
+	}
+
+	return nil
+}
+
+func (c *TieredCacher) SetWithTags(name string, value string, ttl time.Duration, tags ...string) error {
+	return c.SetWithTagsCtx(ctxB, name, value, ttl, tags...)
+}
+
+func (c *TieredCacher) SetWithTagsCtx(ctx context.Context, name string, value string, ttl time.Duration, tags ...string) error {
+	if err := c.remote.SetWithTagsCtx(ctx, name, value, ttl, tags...); err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		ttl = c.remote.expiracy
+	}
+	c.local.set(name, value, ttl)
+	c.publish(ctx, invalidateKey, name)
+	return nil
+}
+
+func (c *TieredCacher) InvalidateTag(tag string) (int64, error) {
+	return c.InvalidateTagCtx(ctxB, tag)
+}
+
+func (c *TieredCacher) InvalidateTagCtx(ctx context.Context, tag string) (int64, error) {
+	names, err := c.remote.invalidateTag(ctx, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	results := make([]CacheResult, 0, len(names))
+	for _, name := range names {
+		results = append(results, CacheResult{Op: CacheOpDelete, Name: name})
+	}
+
+	return int64(len(names)), c.invalidateBatch(ctx, results, nil)
+}
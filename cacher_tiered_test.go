@@ -0,0 +1,242 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredCacher(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	//two replicas sharing the same redis
+	a, err := NewTieredCacher(rds, "tier", 60, TieredCacheConfig{})
+	assert.Nil(t, err)
+	defer a.Close()
+	b, err := NewTieredCacher(rds, "tier", 60, TieredCacheConfig{})
+	assert.Nil(t, err)
+	defer b.Close()
+
+	err = a.Set("config", "v1")
+	assert.Nil(t, err, "should nil")
+
+	//first read on b come from redis, second from memory
+	for i := 0; i < 2; i++ {
+		val, err := b.Get("config")
+		assert.Nil(t, err, "should nil")
+		assert.Equal(t, "v1", val, "should have value")
+	}
+	assert.Equal(t, TieredCacheStats{LocalHits: 1, LocalMisses: 1, RemoteHits: 1}, b.Stats())
+
+	//set on a evict local copy on b
+	err = a.Set("config", "v2")
+	assert.Nil(t, err, "should nil")
+	assert.Eventually(t, func() bool {
+		val, _ := b.Get("config")
+		return val == "v2"
+	}, time.Second, 10*time.Millisecond, "b should see new value")
+
+	//delete on b evict local copy on a
+	err = b.Delete("config")
+	assert.Nil(t, err, "should nil")
+	assert.Eventually(t, func() bool {
+		_, err := a.Get("config")
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "a should see deletion")
+
+	//pattern delete evict matching local copies
+	assert.Nil(t, a.Set("user_1", "x"))
+	assert.Nil(t, a.Set("user_2", "x"))
+	_, err = b.Get("user_1")
+	assert.Nil(t, err, "should nil")
+	deleted, err := a.DeleteByPattern("user_*")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(2), deleted, "should delete matching keys")
+	assert.Eventually(t, func() bool {
+		_, err := b.Get("user_1")
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "b should see pattern deletion")
+}
+
+// failPublishHook fail every PUBLISH sent through the client
+type failPublishHook struct{}
+
+func (failPublishHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (failPublishHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if strings.EqualFold(cmd.Name(), "publish") {
+			cmd.SetErr(errors.New("publish failed"))
+			return cmd.Err()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (failPublishHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if strings.EqualFold(cmd.Name(), "publish") {
+				cmd.SetErr(errors.New("publish failed"))
+				return cmd.Err()
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestTieredCacherErrors(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.Nil(t, err)
+	defer mr.Close()
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	c, err := NewTieredCacher(rds, "tier", 60, TieredCacheConfig{})
+	require.Nil(t, err)
+	defer c.Close()
+
+	//missing key is a miss
+	_, err = c.Get("missing")
+	assert.ErrorIs(t, err, redis.Nil, "should be miss")
+
+	//server error is returned and not counted as miss
+	mr.SetError("ERR boom")
+	_, err = c.Get("broken")
+	assert.NotNil(t, err, "should error")
+	assert.False(t, errors.Is(err, redis.Nil), "should not be miss")
+	mr.SetError("")
+	assert.Equal(t, TieredCacheStats{LocalMisses: 2, RemoteMisses: 1, RemoteErrors: 1}, c.Stats())
+
+	results, _ := c.MGet("missing_many")
+	assert.ErrorIs(t, results[0].Err, ErrCacheMiss, "should be miss")
+	assert.Equal(t, uint64(2), c.Stats().RemoteMisses, "should count batch miss")
+
+	//failed invalidation doesn't fail the write
+	rds.AddHook(failPublishHook{})
+	assert.Nil(t, c.Set("config", "v1"), "should nil")
+	assert.Nil(t, c.Delete("config"), "should nil")
+	_, err = c.MSet(map[string]string{"a": "1"}, time.Minute)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, uint64(3), c.Stats().InvalidationErrors, "should count failed publish")
+}
+
+func TestTieredCacherLocalTTL(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.Nil(t, err)
+	defer mr.Close()
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	//local ttl much longer than the redis keys
+	c, err := NewTieredCacher(rds, "tier", 1, TieredCacheConfig{LocalTTL: time.Hour})
+	require.Nil(t, err)
+	defer c.Close()
+
+	localTTL := func(name string) time.Duration {
+		c.local.mu.Lock()
+		defer c.local.mu.Unlock()
+		el, ok := c.local.items[name]
+		if !ok {
+			return 0
+		}
+		return time.Until(el.Value.(*localEntry).expires)
+	}
+
+	//writes keep the local copy no longer than the remote key
+	assert.Nil(t, c.Set("set", "v"), "should nil")
+	assert.LessOrEqual(t, localTTL("set"), time.Second, "should bounded by expiracy")
+	assert.Nil(t, c.SetWithTags("tagged", "v", 0, "grp"), "should nil")
+	assert.LessOrEqual(t, localTTL("tagged"), time.Second, "should bounded by expiracy")
+	_, err = c.GetOrLoad("loaded", 0, func() (string, error) { return "v", nil })
+	assert.Nil(t, err, "should nil")
+	assert.LessOrEqual(t, localTTL("loaded"), time.Second, "should bounded by expiracy")
+
+	//reads keep the local copy no longer than the remaining remote ttl
+	require.Nil(t, mr.Set("tier_short", "v"))
+	mr.SetTTL("tier_short", 50*time.Millisecond)
+	val, err := c.Get("short")
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "v", val, "should have value")
+	assert.LessOrEqual(t, localTTL("short"), 50*time.Millisecond, "should bounded by remote ttl")
+
+	time.Sleep(60 * time.Millisecond)
+	mr.FastForward(60 * time.Millisecond)
+	_, err = c.Get("short")
+	assert.ErrorIs(t, err, redis.Nil, "should miss once remote expired")
+
+	//mget and getorload hits use remaining ttl as well
+	require.Nil(t, mr.Set("tier_m", "v"))
+	mr.SetTTL("tier_m", 50*time.Millisecond)
+	results, err := c.MGet("m")
+	assert.Nil(t, err, "should nil")
+	assert.Nil(t, results[0].Err, "should hit")
+	assert.LessOrEqual(t, localTTL("m"), 50*time.Millisecond, "should bounded by remote ttl")
+
+	require.Nil(t, mr.Set("tier_g", "v"))
+	mr.SetTTL("tier_g", 50*time.Millisecond)
+	val, err = c.GetOrLoad("g", time.Hour, func() (string, error) { return "loaded", nil })
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "v", val, "should read remote")
+	assert.LessOrEqual(t, localTTL("g"), 50*time.Millisecond, "should bounded by remote ttl")
+
+	//keys without expiry fall back to local ttl
+	require.Nil(t, mr.Set("tier_forever", "v"))
+	_, err = c.Get("forever")
+	assert.Nil(t, err, "should nil")
+	assert.Greater(t, localTTL("forever"), time.Minute, "should use local ttl")
+}
+
+func TestLocalCache(t *testing.T) {
+	l := newLocalCache(2, time.Minute)
+	l.set("a", "1", 0)
+	l.set("b", "2", 0)
+
+	//touch a so b become least recently used
+	_, ok := l.get("a")
+	assert.True(t, ok)
+	l.set("c", "3", 0)
+
+	_, ok = l.get("b")
+	assert.False(t, ok, "least recently used should be evicted")
+	_, ok = l.get("a")
+	assert.True(t, ok, "recently used should stay")
+
+	//short ttl expire entry
+	l.set("d", "4", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = l.get("d")
+	assert.False(t, ok, "expired entry should be gone")
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		value   string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"user_*", "user_1", true},
+		{"user_*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"*/path", "tenant/path", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.match, matchGlob(c.pattern, c.value), c.pattern+" "+c.value)
+	}
+}
@@ -1,10 +1,13 @@
 package tools
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 // this is useful for testing, to predefined behavior of the response
@@ -17,62 +20,332 @@ type CacherV2 interface {
 	Delete(name string) error
 	GetKeysWithParam(name string) ([]string, error)
 	PrintKeys()
+
+	// context-first variants, the caller context is passed to redis so
+	// cancellation and deadlines are respected
+	PingCtx(ctx context.Context) error
+	GetCtx(ctx context.Context, name string) (string, error)
+	SetCtx(ctx context.Context, name string, value string) error
+	SetWithDurationCtx(ctx context.Context, name string, value string, d time.Duration) error
+	DeleteCtx(ctx context.Context, name string) error
+	GetKeysWithParamCtx(ctx context.Context, name string) ([]string, error)
+
+	// cache-aside read, concurrent misses on the same key share one loader call
+	GetOrLoad(name string, ttl time.Duration, loader func() (string, error)) (string, error)
+	GetOrLoadCtx(ctx context.Context, name string, ttl time.Duration, loader func() (string, error)) (string, error)
+
+	// cursor based key iteration limited to cacher prefix, batch is the SCAN count hint
+	ScanKeys(pattern string, batch int64) KeyIterator
+	ScanKeysCtx(ctx context.Context, pattern string, batch int64) KeyIterator
+	DeleteByPattern(pattern string) (int64, error)
+	DeleteByPatternCtx(ctx context.Context, pattern string) (int64, error)
+
+	// batch operations in one round-trip, every name get its own result
+	// so a partial miss doesn't fail the whole batch
+	MGet(names ...string) ([]CacheResult, error)
+	MGetCtx(ctx context.Context, names ...string) ([]CacheResult, error)
+	MSet(values map[string]string, ttl time.Duration) ([]CacheResult, error)
+	MSetCtx(ctx context.Context, values map[string]string, ttl time.Duration) ([]CacheResult, error)
+	DeleteMany(names ...string) ([]CacheResult, error)
+	DeleteManyCtx(ctx context.Context, names ...string) ([]CacheResult, error)
+	Pipeline(fn func(b CacheBatch)) ([]CacheResult, error)
+	PipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error)
+	TxPipeline(fn func(b CacheBatch)) ([]CacheResult, error)
+	TxPipelineCtx(ctx context.Context, fn func(b CacheBatch)) ([]CacheResult, error)
+
+	// tag based group invalidation, InvalidateTag delete every key stored with the tag
+	SetWithTags(name string, value string, ttl time.Duration, tags ...string) error
+	SetWithTagsCtx(ctx context.Context, name string, value string, ttl time.Duration, tags ...string) error
+	InvalidateTag(tag string) (int64, error)
+	InvalidateTagCtx(ctx context.Context, tag string) (int64, error)
+}
+
+// KeyIterator walk over keys returned by ScanKeys, keys include cacher prefix
+//
+//	it := c.ScanKeys("user_*", 100)
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil { ... }
+type KeyIterator interface {
+	Next() bool
+	Key() string
+	Err() error
 }
 
-func NewCacherV2(rdc *redis.Client, prefix string, expiracy int) CacherV2 {
+// operation names used in batch results, metrics and FakeCacher records
+const (
+	CacheOpPing          = "ping"
+	CacheOpGet           = "get"
+	CacheOpSet           = "set"
+	CacheOpDelete        = "delete"
+	CacheOpKeys          = "keys"
+	CacheOpLoad          = "load"
+	CacheOpScan          = "scan"
+	CacheOpDeletePattern = "delete_pattern"
+	CacheOpMGet          = "mget"
+	CacheOpMSet          = "mset"
+	CacheOpDeleteMany    = "delete_many"
+	CacheOpPipeline      = "pipeline"
+	CacheOpTxPipeline    = "tx_pipeline"
+	CacheOpSetTags       = "set_tags"
+	CacheOpInvalidateTag = "invalidate_tag"
+)
+
+// default SCAN count and delete batch size
+const defaultScanBatch = 100
+
+func NewCacherV2(rdc redis.UniversalClient, prefix string, expiracy int, opts ...CacherOption) CacherV2 {
 	return &cacher{
 		rdb:      rdc,
 		expiracy: time.Duration(expiracy) * time.Second,
 		prefix:   prefix,
+		opts:     newCacherOptions(opts),
 	}
 }
 
 type cacher struct {
-	rdb      *redis.Client
+	rdb      redis.UniversalClient
 	expiracy time.Duration
 	prefix   string
+	opts     cacherOptions
+	group    singleflight.Group
 }
 
-func (c *cacher) PrintKeys() {
-	var cursor uint64
-	for {
-		var keys []string
-		var err error
-		keys, cursor, err = c.rdb.Scan(ctxB, cursor, "", 0).Result()
-		if err != nil {
-			panic(err)
-		}
+func (c *cacher) key(name string) string {
+	return c.prefix + "_" + name
+}
 
-		for _, key := range keys {
-			fmt.Println("key", key)
-		}
+func (c *cacher) PrintKeys() {
+	it := c.ScanKeys("*", defaultScanBatch)
+	for it.Next() {
+		fmt.Println("key", it.Key())
+	}
 
-		if cursor == 0 { // no more keys
-			break
-		}
+	if err := it.Err(); err != nil {
+		panic(err)
 	}
 }
 
 func (c *cacher) SetWithDuration(name string, value string, d time.Duration) error {
-	return c.rdb.Set(ctxB, c.prefix+"_"+name, value, d).Err()
+	return c.SetWithDurationCtx(ctxB, name, value, d)
 }
 
 func (c *cacher) Set(name string, value string) error {
-	return c.rdb.Set(ctxB, c.prefix+"_"+name, value, c.expiracy).Err()
+	return c.SetCtx(ctxB, name, value)
 }
 
 func (c *cacher) Get(name string) (string, error) {
-	return c.rdb.Get(ctxB, c.prefix+"_"+name).Result()
+	return c.GetCtx(ctxB, name)
 }
 
 func (c *cacher) Delete(name string) error {
-	return c.rdb.Del(ctxB, c.prefix+"_"+name).Err()
+	return c.DeleteCtx(ctxB, name)
 }
 
 func (c *cacher) Ping() error {
-	return c.rdb.Ping(ctxB).Err()
+	return c.PingCtx(ctxB)
 }
 
 func (c *cacher) GetKeysWithParam(name string) ([]string, error) {
-	return c.rdb.Keys(ctxB, c.prefix+"_"+name).Result()
+	return c.GetKeysWithParamCtx(ctxB, name)
+}
+
+func (c *cacher) SetWithDurationCtx(ctx context.Context, name string, value string, d time.Duration) error {
+	return c.rdb.Set(ctx, c.key(name), value, d).Err()
+}
+
+func (c *cacher) SetCtx(ctx context.Context, name string, value string) error {
+	return c.rdb.Set(ctx, c.key(name), value, c.expiracy).Err()
+}
+
+func (c *cacher) getRaw(ctx context.Context, name string) (string, error) {
+	return c.rdb.Get(ctx, c.key(name)).Result()
+}
+
+func (c *cacher) GetCtx(ctx context.Context, name string) (string, error) {
+	val, err := c.getRaw(ctx, name)
+	if err == nil && val == negativeMarker {
+		return "", redis.Nil
+	}
+	return val, err
+}
+
+func (c *cacher) DeleteCtx(ctx context.Context, name string) error {
+	return c.rdb.Del(ctx, c.key(name)).Err()
+}
+
+func (c *cacher) PingCtx(ctx context.Context) error {
+	return c.rdb.Ping(ctx).Err()
+}
+
+func (c *cacher) GetKeysWithParamCtx(ctx context.Context, name string) ([]string, error) {
+	return collectKeys(c.ScanKeysCtx(ctx, name, defaultScanBatch))
+}
+
+func (c *cacher) GetOrLoad(name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	return c.GetOrLoadCtx(ctxB, name, ttl, loader)
+}
+
+func (c *cacher) GetOrLoadCtx(ctx context.Context, name string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	if ttl <= 0 {
+		ttl = c.expiracy
+	}
+
+	return getOrLoad(ctx, c, &c.group, c.opts, name, ttl, loader)
+}
+
+func (c *cacher) ScanKeys(pattern string, batch int64) KeyIterator {
+	return c.ScanKeysCtx(ctxB, pattern, batch)
+}
+
+func (c *cacher) ScanKeysCtx(ctx context.Context, pattern string, batch int64) KeyIterator {
+	return scanKeys(ctx, c.rdb, c.key(pattern), batch)
+}
+
+// scanKeys iterate keys matching full pattern, prefix must already be applied
+func scanKeys(ctx context.Context, rdb redis.UniversalClient, match string, batch int64) KeyIterator {
+	if batch <= 0 {
+		batch = defaultScanBatch
+	}
+
+	//SCAN on cluster only see a single node, walk every master instead
+	if cluster, ok := rdb.(*redis.ClusterClient); ok {
+		return newClusterKeyIterator(ctx, cluster, match, batch)
+	}
+
+	return &redisKeyIterator{
+		ctx: ctx,
+		it:  rdb.Scan(ctx, 0, match, batch).Iterator(),
+	}
+}
+
+func collectKeys(it KeyIterator) ([]string, error) {
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+
+	return keys, it.Err()
+}
+
+func (c *cacher) DeleteByPattern(pattern string) (int64, error) {
+	return c.DeleteByPatternCtx(ctxB, pattern)
+}
+
+// DeleteByPatternCtx delete keys matching pattern, keys created by other writers
+// while it runs are not guaranteed to be deleted
+func (c *cacher) DeleteByPatternCtx(ctx context.Context, pattern string) (int64, error) {
+	var deleted int64
+	batch := make([]string, 0, defaultScanBatch)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		//one DEL per key keep the pipeline valid on cluster
+		cmds, err := c.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, key := range batch {
+				p.Del(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("fail delete keys : %w", err)
+		}
+
+		for _, cmd := range cmds {
+			deleted += cmd.(*redis.IntCmd).Val()
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	it := c.ScanKeysCtx(ctx, pattern, defaultScanBatch)
+	for it.Next() {
+		batch = append(batch, it.Key())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return deleted, fmt.Errorf("fail scan keys : %w", err)
+	}
+
+	return deleted, flush()
+}
+
+type redisKeyIterator struct {
+	ctx context.Context
+	it  *redis.ScanIterator
+}
+
+func (i *redisKeyIterator) Next() bool {
+	return i.it.Next(i.ctx)
+}
+
+func (i *redisKeyIterator) Key() string {
+	return i.it.Val()
+}
+
+func (i *redisKeyIterator) Err() error {
+	return i.it.Err()
+}
+
+// clusterKeyIterator scan master nodes one after another
+type clusterKeyIterator struct {
+	ctx   context.Context
+	nodes []*redis.Client
+	match string
+	batch int64
+	cur   *redis.ScanIterator
+	err   error
+}
+
+func newClusterKeyIterator(ctx context.Context, cluster *redis.ClusterClient, match string, batch int64) *clusterKeyIterator {
+	it := &clusterKeyIterator{ctx: ctx, match: match, batch: batch}
+
+	var mu sync.Mutex
+	it.err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		mu.Lock()
+		it.nodes = append(it.nodes, node)
+		mu.Unlock()
+		return nil
+	})
+
+	return it
+}
+
+func (i *clusterKeyIterator) Next() bool {
+	for i.err == nil {
+		if i.cur != nil {
+			if i.cur.Next(i.ctx) {
+				return true
+			}
+			if i.err = i.cur.Err(); i.err != nil {
+				return false
+			}
+		}
+
+		if len(i.nodes) == 0 {
+			return false
+		}
+
+		i.cur = i.nodes[0].Scan(i.ctx, 0, i.match, i.batch).Iterator()
+		i.nodes = i.nodes[1:]
+	}
+
+	return false
+}
+
+func (i *clusterKeyIterator) Key() string {
+	return i.cur.Val()
+}
+
+func (i *clusterKeyIterator) Err() error {
+	return i.err
 }
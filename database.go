@@ -1,13 +1,24 @@
 package tools
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"fmt"
+	"hash/crc32"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	"go.uber.org/zap"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -41,40 +52,298 @@ type DBConfiguration struct {
 	ConnectTimeOut int
 	MaxOpenConn    int
 	MaxIdleConn    int
-	Migrate        bool
+	Migrate        bool  //apply Migrations when connecting
+	Migrations     fs.FS //versioned *.up.sql / *.down.sql files, see Migrator
 	PreparedStmt   bool
+
+	SSLMode          string        //disable (default), require, verify-ca or verify-full
+	SSLRootCert      string        //path of CA certificate
+	SSLCert          string        //path of client certificate
+	SSLKey           string        //path of client key
+	StatementTimeout time.Duration //postgres statement_timeout, mysql max_execution_time (select only)
+	TimeZone         string        //session time zone, e.g. "UTC" or "Asia/Jakarta"
+	ConnMaxLifetime  time.Duration //default 1 hour
+	ConnMaxIdleTime  time.Duration
+	SlowThreshold    time.Duration //gorm slow query threshold, default 1 second
+	Logger           *zap.Logger   //when set gorm log through zap, Logging then log every query instead of slow ones only
+	LogSQLParams     bool          //log bind parameters with zap logger, redacted by default
+
+	Replicas             []ReplicaConfig //read replicas used by OpenGormDB, see UseReplicas
+	ReplicaPolicy        string          //round_robin (default) or random
+	ReplicaMaxLag        time.Duration   //replica lagging more is skipped, 0 disable lag check
+	ReplicaCheckInterval time.Duration   //default 10 seconds
 }
 
 const (
 	Mysql      = "mysql"
 	Postgresql = "postgres"
+	Sqlite     = "sqlite" //pure go sqlite, DBName is file path or ":memory:"
+)
+
+const (
+	SSLModeDisable    = "disable"
+	SSLModeRequire    = "require"
+	SSLModeVerifyCA   = "verify-ca"
+	SSLModeVerifyFull = "verify-full"
 )
 
 func ConnectDB(cfg DBConfiguration) (*sql.DB, error) {
-	connString := makeConnString(cfg.DbType, cfg.SessionName, cfg.Host, cfg.Port, cfg.Username, cfg.DBName, cfg.Password, cfg.ConnectTimeOut)
+	if cfg.Migrate && cfg.Migrations == nil {
+		return nil, fmt.Errorf("migrate is enabled but migrations is nil")
+	}
+
+	connString, err := makeConnString(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	sql, err := sql.Open(cfg.DbType, connString)
 	if err != nil {
 		return nil, err
 	}
 
+	lifetime := cfg.ConnMaxLifetime
+	if lifetime <= 0 {
+		lifetime = time.Hour
+	}
+
+	if cfg.DbType == Sqlite && sqliteInMemory(cfg) {
+		//every connection of in memory database is a new empty database,
+		//so keep exactly one connection open for the life of the pool
+		cfg.MaxOpenConn, cfg.MaxIdleConn = 1, 1
+		lifetime, cfg.ConnMaxIdleTime = 0, 0
+	}
+
 	sql.SetMaxIdleConns(cfg.MaxIdleConn)
 	sql.SetMaxOpenConns(cfg.MaxOpenConn)
-	sql.SetConnMaxLifetime(time.Hour)
+	sql.SetConnMaxLifetime(lifetime)
+	sql.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if cfg.Migrate {
+		if err := Migrate(context.Background(), sql, cfg.DbType, cfg.Migrations); err != nil {
+			sql.Close()
+			return nil, err
+		}
+	}
 
 	return sql, nil
 }
 
-func makeConnString(dbtype, conName, host, port, user, dbname, password string, timeOut int) string {
-	if dbtype == Postgresql {
-		return fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s connect_timeout=%d application_name=%s",
-			host, port, user, dbname, password, timeOut, conName)
+func makeConnString(cfg DBConfiguration) (string, error) {
+	switch cfg.DbType {
+	case Postgresql:
+		return makePostgresConnString(cfg), nil
+	case Sqlite:
+		return makeSqliteConnString(cfg), nil
 	}
 
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		user, password, host, port, dbname)
+	return makeMysqlConnString(cfg)
+}
+
+func sqliteInMemory(cfg DBConfiguration) bool {
+	return cfg.DBName == "" || cfg.DBName == ":memory:"
+}
+
+// makeSqliteConnString enable foreign keys and wait ConnectTimeOut seconds (default 5) on locked database
+func makeSqliteConnString(cfg DBConfiguration) string {
+	path := cfg.DBName
+	if sqliteInMemory(cfg) {
+		path = ":memory:"
+	}
+
+	busyTimeout := cfg.ConnectTimeOut * 1000
+	if busyTimeout <= 0 {
+		busyTimeout = 5000
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout("+strconv.Itoa(busyTimeout)+")")
+	if !sqliteInMemory(cfg) {
+		//readers do not block the writer
+		params.Add("_pragma", "journal_mode(WAL)")
+	}
+	//take write lock on begin, so concurrent transactions wait instead of failing on upgrade
+	params.Set("_txlock", "immediate")
+
+	return path + "?" + params.Encode()
+}
+
+func makePostgresConnString(cfg DBConfiguration) string {
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = SSLModeDisable
+	}
+
+	params := [][2]string{
+		{"host", cfg.Host},
+		{"port", cfg.Port},
+		{"user", cfg.Username},
+		{"dbname", cfg.DBName},
+		{"password", cfg.Password},
+		{"sslmode", sslMode},
+		{"sslrootcert", cfg.SSLRootCert},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+		{"connect_timeout", strconv.Itoa(cfg.ConnectTimeOut)},
+		{"application_name", cfg.SessionName},
+		//unknown keys are sent by lib/pq as session parameters
+		{"search_path", cfg.Schema},
+		{"timezone", cfg.TimeZone},
+	}
+	if cfg.StatementTimeout > 0 {
+		params = append(params, [2]string{"statement_timeout", strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)})
+	}
+
+	parts := make([]string, 0, len(params))
+	for _, p := range params {
+		if p[1] == "" {
+			continue
+		}
+		parts = append(parts, p[0]+"="+quotePostgresValue(p[1]))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// quote value when it contain space, quote or backslash
+func quotePostgresValue(v string) string {
+	if !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+func makeMysqlConnString(cfg DBConfiguration) (string, error) {
+	c := mysqldriver.NewConfig()
+	c.User = cfg.Username
+	c.Passwd = cfg.Password
+	c.Net = "tcp"
+	c.Addr = cfg.Host + ":" + cfg.Port
+	c.DBName = cfg.DBName
+	c.ParseTime = true
+	c.Loc = time.Local
+	c.Timeout = time.Duration(cfg.ConnectTimeOut) * time.Second
+	c.Params = map[string]string{"charset": "utf8mb4"}
+
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return "", fmt.Errorf("fail load time zone %s : %w", cfg.TimeZone, err)
+		}
+		c.Loc = loc
+		//named zones need the server time zone tables loaded
+		c.Params["time_zone"] = "'" + cfg.TimeZone + "'"
+	}
+
+	if cfg.StatementTimeout > 0 {
+		c.Params["max_execution_time"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+
+	tlsName, err := mysqlTLSProfile(cfg)
+	if err != nil {
+		return "", err
+	}
+	c.TLSConfig = tlsName
+
+	return c.FormatDSN(), nil
+}
+
+// mysqlTLSProfile register tls config for the ssl settings and return its name
+func mysqlTLSProfile(cfg DBConfiguration) (string, error) {
+	if cfg.SSLMode == "" || cfg.SSLMode == SSLModeDisable {
+		return "", nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.Host,
+	}
+
+	if cfg.SSLRootCert != "" {
+		pem, err := os.ReadFile(cfg.SSLRootCert)
+		if err != nil {
+			return "", fmt.Errorf("fail read database ca cert : %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("fail parse database ca cert %s", cfg.SSLRootCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.SSLCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.SSLCert, cfg.SSLKey)
+		if err != nil {
+			return "", fmt.Errorf("fail load database client cert : %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch cfg.SSLMode {
+	case SSLModeRequire:
+		tlsConfig.InsecureSkipVerify = true
+	case SSLModeVerifyCA:
+		//verify the chain but not the host name
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("database server sent no certificate")
+			}
+			opts := x509.VerifyOptions{Roots: tlsConfig.RootCAs, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	case SSLModeVerifyFull:
+	default:
+		return "", fmt.Errorf("unknown ssl mode %s", cfg.SSLMode)
+	}
+
+	//same settings always map to same profile name
+	profile := strings.Join([]string{cfg.Host, cfg.Port, cfg.SSLMode, cfg.SSLRootCert, cfg.SSLCert, cfg.SSLKey}, "|")
+	name := "tools-" + strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(profile))), 10)
+	if err := mysqldriver.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", fmt.Errorf("fail register database tls config : %w", err)
+	}
+
+	return name, nil
+}
+
+// gormLogger build zap adapter when cfg.Logger is set, stdout logger otherwise
+func gormLogger(cfg DBConfiguration) logger.Interface {
+	slowThreshold := cfg.SlowThreshold
+	if slowThreshold <= 0 {
+		slowThreshold = time.Second
+	}
+
+	if cfg.Logger == nil {
+		return createLogger(cfg.Logging, slowThreshold)
+	}
+
+	level := logger.Warn
+	if cfg.Logging {
+		level = logger.Info
+	}
+
+	return NewZapGormLogger(cfg.Logger, ZapGormLoggerConfig{
+		SlowThreshold: slowThreshold,
+		LogLevel:      level,
+		ShowParams:    cfg.LogSQLParams,
+	})
 }
 
 func CreateLogger(debug bool) logger.Interface {
+	return createLogger(debug, time.Second)
+}
+
+func createLogger(debug bool, slowThreshold time.Duration) logger.Interface {
 	level := logger.Silent
 	if debug {
 		level = logger.Info
@@ -83,27 +352,61 @@ func CreateLogger(debug bool) logger.Interface {
 	return logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
 		logger.Config{
-			SlowThreshold:             time.Second, // Slow SQL threshold
-			LogLevel:                  level,       // Log level
-			IgnoreRecordNotFoundError: false,       // Ignore ErrRecordNotFound error for logger
-			Colorful:                  true,        // Disable color
+			SlowThreshold:             slowThreshold, // Slow SQL threshold
+			LogLevel:                  level,         // Log level
+			IgnoreRecordNotFoundError: false,         // Ignore ErrRecordNotFound error for logger
+			Colorful:                  true,          // Disable color
 		},
 	)
 }
 
+// OpenGormDB connect with ConnectDB and open gorm with NewGormDB, logger,
+// prepared statement and read replicas are taken from cfg
+func OpenGormDB(cfg DBConfiguration) (*gorm.DB, error) {
+	conn, err := ConnectDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := NewGormDB(cfg.DbType, conn, gormLogger(cfg), cfg.PreparedStmt)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if len(cfg.Replicas) > 0 {
+		if err := UseReplicas(db, cfg); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
 func NewGormDB(dbtype string, conn *sql.DB, log logger.Interface, preparedStatement bool) (*gorm.DB, error) {
 	if dbtype == Mysql {
-		return gorm.Open(mysql.New(mysql.Config{
-			Conn:                      conn,
-			SkipInitializeWithVersion: true,
-		}), &gorm.Config{
+		return gorm.Open(gormDialector(dbtype, conn), &gorm.Config{
 			SkipDefaultTransaction: true,
 			Logger:                 log,
 			PrepareStmt:            preparedStatement,
 		})
 	}
 
-	return gorm.Open(postgres.New(
-		postgres.Config{Conn: conn}),
+	return gorm.Open(gormDialector(dbtype, conn),
 		&gorm.Config{Logger: log, PrepareStmt: preparedStatement})
 }
+
+func gormDialector(dbtype string, conn *sql.DB) gorm.Dialector {
+	switch dbtype {
+	case Mysql:
+		return mysql.New(mysql.Config{
+			Conn:                      conn,
+			SkipInitializeWithVersion: true,
+		})
+	case Sqlite:
+		return sqlite.Dialector{Conn: conn}
+	}
+
+	return postgres.New(postgres.Config{Conn: conn})
+}
@@ -2,8 +2,11 @@ package tools
 
 import (
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMysqlDatabase(t *testing.T) {
@@ -94,3 +97,105 @@ func TestFailConnection(t *testing.T) {
 	err := conn.Ping()
 	assert.NotNil(t, err, "error should exist because fail connect to database")
 }
+
+func TestMakeConnString(t *testing.T) {
+	//postgres keep sslmode disable by default and apply schema
+	dsn, err := makeConnString(DBConfiguration{
+		DbType:           Postgresql,
+		Host:             "127.0.0.1",
+		Port:             "5432",
+		DBName:           "tgdata",
+		Username:         "postgres",
+		Password:         "it's secret",
+		SessionName:      "test",
+		ConnectTimeOut:   30,
+		Schema:           "tenant_a",
+		StatementTimeout: 5 * time.Second,
+		TimeZone:         "UTC",
+	})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, `host=127.0.0.1 port=5432 user=postgres dbname=tgdata password='it\'s secret' sslmode=disable connect_timeout=30 application_name=test search_path=tenant_a timezone=UTC statement_timeout=5000`, dsn, "should build postgres dsn")
+
+	dsn, err = makeConnString(DBConfiguration{
+		DbType:      Postgresql,
+		Host:        "db",
+		Port:        "5432",
+		SSLMode:     SSLModeVerifyFull,
+		SSLRootCert: "/certs/ca.pem",
+	})
+	assert.Nil(t, err, "should nil")
+	assert.Contains(t, dsn, "sslmode=verify-full sslrootcert=/certs/ca.pem", "should pass ssl settings")
+
+	//mysql
+	dsn, err = makeConnString(DBConfiguration{
+		DbType:           Mysql,
+		Host:             "127.0.0.1",
+		Port:             "3306",
+		DBName:           "tgdata",
+		Username:         "root",
+		Password:         "root",
+		ConnectTimeOut:   30,
+		StatementTimeout: 2 * time.Second,
+	})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "root:root@tcp(127.0.0.1:3306)/tgdata?loc=Local&parseTime=true&timeout=30s&charset=utf8mb4&max_execution_time=2000", dsn, "should build mysql dsn")
+
+	dsn, err = makeConnString(DBConfiguration{
+		DbType:  Mysql,
+		Host:    "127.0.0.1",
+		Port:    "3306",
+		SSLMode: SSLModeRequire,
+	})
+	assert.Nil(t, err, "should nil")
+	assert.Contains(t, dsn, "tls=tools-", "should use registered tls profile")
+
+	_, err = makeConnString(DBConfiguration{DbType: Mysql, SSLMode: "bogus"})
+	assert.NotNil(t, err, "should error on unknown ssl mode")
+
+	_, err = makeConnString(DBConfiguration{DbType: Mysql, TimeZone: "Nowhere/City"})
+	assert.NotNil(t, err, "should error on unknown time zone")
+
+	//sqlite
+	dsn, err = makeConnString(DBConfiguration{DbType: Sqlite})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, ":memory:?_pragma=foreign_keys%281%29&_pragma=busy_timeout%285000%29&_txlock=immediate", dsn, "should build in memory dsn")
+
+	dsn, err = makeConnString(DBConfiguration{DbType: Sqlite, DBName: "/tmp/app.db", ConnectTimeOut: 2})
+	assert.Nil(t, err, "should nil")
+	assert.Contains(t, dsn, "/tmp/app.db?", "should use file")
+	assert.Contains(t, dsn, "busy_timeout%282000%29", "should use connect timeout")
+	assert.Contains(t, dsn, "journal_mode%28WAL%29", "should use wal")
+}
+
+func TestSqliteDatabase(t *testing.T) {
+	type localItem struct {
+		ID   int64
+		Name string
+	}
+
+	db, err := OpenGormDB(DBConfiguration{
+		DbType:      Sqlite,
+		DBName:      ":memory:",
+		MaxOpenConn: 10,
+		Migrate:     true,
+		Migrations: fstest.MapFS{
+			"0001_items.up.sql":   {Data: []byte("CREATE TABLE local_items (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+			"0001_items.down.sql": {Data: []byte("DROP TABLE local_items;")},
+		},
+	})
+	require.Nil(t, err)
+
+	sqlDB, err := db.DB()
+	assert.Nil(t, err, "should nil")
+	defer sqlDB.Close()
+	assert.Equal(t, 1, sqlDB.Stats().MaxOpenConnections, "should keep single connection")
+
+	assert.Nil(t, db.Create(&localItem{Name: "one"}).Error, "should nil")
+
+	var items []localItem
+	assert.Nil(t, db.Find(&items).Error, "should nil")
+	assert.Equal(t, []localItem{{ID: 1, Name: "one"}}, items, "should read migrated table")
+
+	_, err = ConnectDB(DBConfiguration{DbType: Sqlite, Migrate: true})
+	assert.NotNil(t, err, "should require migrations")
+}
@@ -2,104 +2,536 @@ package tools
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"io/fs"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"gorm.io/driver/postgres"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 )
 
 type DBManager struct {
-	connections map[string]*gorm.DB
+	connections map[string]*tenantPool
+	retired     map[*tenantPool]struct{} //evicted pools waiting for running queries
 	mu          sync.RWMutex
+	group       singleflight.Group
 	config      DBConfiguration
+	credentials CredentialProvider
+	open        func(cfg DBConfiguration) (*gorm.DB, error)
+
+	maxPools       int
+	idleTimeout    time.Duration
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	evictionGrace  time.Duration
+	poolSizes      map[string]poolSize
+
+	schemaMode bool
+	shared     *gorm.DB //base configuration pool serving every tenant schema
+
+	stop chan struct{}
+	once sync.Once
+}
+
+type tenantPool struct {
+	key      string
+	tenant   TenantInfo
+	db       *gorm.DB
+	sqlDB    *sql.DB
+	shared   bool         //session on the shared pool, nothing to close
+	lastUsed atomic.Int64 //unix nano, updated without exclusive lock
+}
+
+func (p *tenantPool) touch() {
+	p.lastUsed.Store(time.Now().UnixNano())
+}
+
+type poolSize struct {
+	maxOpen int
+	maxIdle int
+}
+
+type DBManagerOption func(*DBManager)
+
+// WithCredentialProvider resolve host, port, user and password per tenant
+func WithCredentialProvider(p CredentialProvider) DBManagerOption {
+	return func(m *DBManager) {
+		m.credentials = p
+	}
+}
+
+// WithMaxPools keep at most n tenant pools open, least recently used one is evicted first
+func WithMaxPools(n int) DBManagerOption {
+	return func(m *DBManager) {
+		m.maxPools = n
+	}
+}
+
+// WithIdleTimeout evict tenant pools not used for d
+func WithIdleTimeout(d time.Duration) DBManagerOption {
+	return func(m *DBManager) {
+		m.idleTimeout = d
+	}
+}
+
+// WithHealthCheck ping every tenant pool each interval and rebuild the ones failing
+func WithHealthCheck(interval, timeout time.Duration) DBManagerOption {
+	return func(m *DBManager) {
+		m.healthInterval = interval
+		m.healthTimeout = timeout
+	}
 }
 
-// Multi database connection manager
-func NewDBManager(defaultConfig DBConfiguration) *DBManager {
-	return &DBManager{
-		connections: make(map[string]*gorm.DB),
+// WithEvictionGrace keep evicted pool open at least d and until its running queries finish,
+// so handles already returned by GetConnection keep working. Default 30 seconds.
+func WithEvictionGrace(d time.Duration) DBManagerOption {
+	return func(m *DBManager) {
+		m.evictionGrace = d
+	}
+}
+
+// WithTenantPoolSize override MaxOpenConn and MaxIdleConn of single tenant, key is tenant ID or DBName.
+// Ignored in schema per tenant mode where every tenant share the base pool.
+func WithTenantPoolSize(key string, maxOpen, maxIdle int) DBManagerOption {
+	return func(m *DBManager) {
+		m.poolSizes[key] = poolSize{maxOpen: maxOpen, maxIdle: maxIdle}
+	}
+}
+
+// WithSchemaPerTenant serve every tenant from one shared pool of the base configuration
+// with search_path set to TenantInfo.Schema per statement, so models, raw sql and Table()
+// all stay in the tenant schema. Postgres only, prepared statement mode is not supported.
+// Tenant is keyed by ID when set, otherwise Schema.
+func WithSchemaPerTenant() DBManagerOption {
+	return func(m *DBManager) {
+		m.schemaMode = true
+	}
+}
+
+// Multi database connection manager, call CloseConnections to stop background checks.
+// With Sqlite every tenant get its own database file, TenantInfo.DBName is the file path.
+func NewDBManager(defaultConfig DBConfiguration, opts ...DBManagerOption) *DBManager {
+	m := &DBManager{
+		connections: make(map[string]*tenantPool),
+		retired:     make(map[*tenantPool]struct{}),
 		config:      defaultConfig,
+		credentials: baseCredentials{},
+		open:        OpenGormDB,
+		poolSizes:   make(map[string]poolSize),
+		stop:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(m)
 	}
+
+	if m.healthTimeout <= 0 {
+		m.healthTimeout = 5 * time.Second
+	}
+	if m.evictionGrace <= 0 {
+		m.evictionGrace = 30 * time.Second
+	}
+
+	interval := m.healthInterval
+	if interval <= 0 {
+		interval = m.idleTimeout / 2
+	}
+	if interval > 0 {
+		go m.maintain(interval)
+	}
+
+	return m
 }
 
 /*
-Use context to pass value of database and db username
+Use WithTenant to pass tenant database
 example :
-ctx.WithValue("dbname", "db1")
-ctx.WithValue("dbuser", "user1")
+ctx = tools.WithTenant(ctx, tools.TenantInfo{DBName: "db1", DBUser: "user1"})
 */
 func (m *DBManager) GetConnection(ctx context.Context) (*gorm.DB, error) {
-	dbName := fmt.Sprintf("%s", ctx.Value("dbname"))
-	dbUser := fmt.Sprintf("%s", ctx.Value("dbuser"))
-	if dbName == "" {
-		return nil, fmt.Errorf("database name is required")
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrTenantMissing
 	}
 
-	if dbUser == "" {
-		return nil, fmt.Errorf("database username is required")
+	if err := m.validate(tenant); err != nil {
+		return nil, err
 	}
 
+	if db, ok := m.lookup(m.tenantKey(tenant)); ok {
+		return db, nil
+	}
+
+	return m.createConnection(ctx, tenant)
+}
+
+func (m *DBManager) validate(tenant TenantInfo) error {
+	if m.schemaMode {
+		return validateSchemaName(tenant.Schema)
+	}
+	return tenant.validate()
+}
+
+func (m *DBManager) tenantKey(tenant TenantInfo) string {
+	if m.schemaMode && tenant.ID == "" {
+		return tenant.Schema
+	}
+	return tenant.key()
+}
+
+// ProvisionTenant create schema of tenant and apply migrations inside it,
+// only available in schema per tenant mode
+func (m *DBManager) ProvisionTenant(ctx context.Context, tenant TenantInfo, migrations fs.FS) error {
+	if !m.schemaMode {
+		return fmt.Errorf("provision tenant require schema per tenant mode")
+	}
+
+	if err := m.validate(tenant); err != nil {
+		return err
+	}
+
+	shared, err := m.sharedDB()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := shared.DB()
+	if err != nil {
+		return err
+	}
+
+	return ProvisionTenantSchema(ctx, sqlDB, m.config.DbType, tenant.Schema, migrations)
+}
+
+// sharedDB open pool of the base configuration on first use
+func (m *DBManager) sharedDB() (*gorm.DB, error) {
 	m.mu.RLock()
-	db, exists := m.connections[dbName]
+	db := m.shared
 	m.mu.RUnlock()
 
-	if exists {
+	if db != nil {
 		return db, nil
 	}
 
-	return m.createConnection(dbName, dbUser)
-}
+	v, err, _ := m.group.Do("\x00shared", func() (interface{}, error) {
+		m.mu.RLock()
+		db := m.shared
+		m.mu.RUnlock()
+		if db != nil {
+			return db, nil
+		}
 
-func (m *DBManager) createConnection(dbname, dbuser string) (*gorm.DB, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+		db, err := m.open(m.config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to shared database: %w", err)
+		}
+		if err := registerSchemaScope(db); err != nil {
+			CloseReplicas(db)
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+			return nil, fmt.Errorf("fail register schema scope : %w", err)
+		}
 
-	// Double-check if connection was created while waiting for lock
-	if db, exists := m.connections[dbname]; exists {
+		m.mu.Lock()
+		m.shared = db
+		m.mu.Unlock()
 		return db, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*gorm.DB), nil
+}
+
+// lookup return cached pool and mark it as recently used
+func (m *DBManager) lookup(key string) (*gorm.DB, bool) {
+	m.mu.RLock()
+	pool, exists := m.connections[key]
+	m.mu.RUnlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	pool.touch()
+	return pool.db, true
+}
+
+func (m *DBManager) createConnection(ctx context.Context, tenant TenantInfo) (*gorm.DB, error) {
+	key := m.tenantKey(tenant)
+
+	// concurrent requests of same tenant share single pool creation
+	v, err, _ := m.group.Do(key, func() (interface{}, error) {
+		if db, ok := m.lookup(key); ok {
+			return db, nil
+		}
+
+		pool, err := m.openPool(ctx, tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		m.store(pool)
+		return pool.db, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*gorm.DB), nil
+}
+
+func (m *DBManager) openPool(ctx context.Context, tenant TenantInfo) (*tenantPool, error) {
+	if m.schemaMode {
+		return m.openSchemaPool(tenant)
+	}
+
+	cred, err := m.credentials.Credentials(ctx, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("fail resolve credentials of tenant %s : %w", tenant.key(), err)
 	}
 
 	// Clone base config and modify for tenant
-	dbConfig := m.config
-	dbConfig.DBName = dbname
-	dbConfig.Username = dbuser
+	dbConfig, err := tenantConfig(m.config, tenant, cred)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create new connection
-	sqlConn, err := ConnectDB(dbConfig)
+	if size, ok := m.poolSizes[tenant.key()]; ok {
+		dbConfig.MaxOpenConn = size.maxOpen
+		dbConfig.MaxIdleConn = size.maxIdle
+	}
+
+	db, err := m.open(dbConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to tenant database: %w", err)
 	}
 
-	// Initialize GORM
-	db, err := gorm.Open(postgres.New(postgres.Config{
-		Conn: sqlConn,
-	}), &gorm.Config{})
+	sqlDB, err := db.DB()
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize GORM: %w", err)
+		return nil, fmt.Errorf("fail get sql db of tenant %s : %w", tenant.key(), err)
+	}
+
+	return newTenantPool(m.tenantKey(tenant), tenant, db, sqlDB), nil
+}
+
+func (m *DBManager) openSchemaPool(tenant TenantInfo) (*tenantPool, error) {
+	if m.config.DbType != Postgresql {
+		return nil, fmt.Errorf("schema per tenant is only supported on postgres")
+	}
+
+	shared, err := m.sharedDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := shared.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := newTenantPool(m.tenantKey(tenant), tenant, scopeSchema(shared, sqlDB, tenant.Schema), sqlDB)
+	pool.shared = true
+	return pool, nil
+}
+
+func newTenantPool(key string, tenant TenantInfo, db *gorm.DB, sqlDB *sql.DB) *tenantPool {
+	pool := &tenantPool{key: key, tenant: tenant, db: db, sqlDB: sqlDB}
+	pool.touch()
+	return pool
+}
+
+// close release the pool and its replicas
+func (p *tenantPool) close() error {
+	if p.shared {
+		return nil
+	}
+	CloseReplicas(p.db)
+	return p.sqlDB.Close()
+}
+
+// store add pool and evict least recently used pools above the limit
+func (m *DBManager) store(pool *tenantPool) {
+	key := pool.key
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, exists := m.connections[key]; exists {
+		m.retire(old)
+	}
+	m.connections[key] = pool
+
+	for m.maxPools > 0 && len(m.connections) > m.maxPools {
+		oldest := ""
+		var oldestUsed int64
+		for k, p := range m.connections {
+			if k == key {
+				continue
+			}
+			if used := p.lastUsed.Load(); oldest == "" || used < oldestUsed {
+				oldest, oldestUsed = k, used
+			}
+		}
+		m.evict(oldest)
+	}
+}
+
+// evict forget tenant pool and retire it, caller must hold the lock
+func (m *DBManager) evict(key string) {
+	pool, exists := m.connections[key]
+	if !exists {
+		return
+	}
+
+	delete(m.connections, key)
+	m.retire(pool)
+}
+
+// retire close pool after the eviction grace once no connection is in use,
+// caller must hold the lock
+func (m *DBManager) retire(pool *tenantPool) {
+	if pool.shared {
+		return
+	}
+	m.retired[pool] = struct{}{}
+
+	var check func()
+	check = func() {
+		m.mu.Lock()
+		_, waiting := m.retired[pool]
+		busy := waiting && pool.sqlDB.Stats().InUse > 0
+		if waiting && !busy {
+			delete(m.retired, pool)
+		}
+		m.mu.Unlock()
+
+		switch {
+		case !waiting:
+			//closed by CloseConnections
+		case busy:
+			time.AfterFunc(m.evictionGrace, check)
+		default:
+			pool.close()
+		}
+	}
+	time.AfterFunc(m.evictionGrace, check)
+}
+
+func (m *DBManager) maintain(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.evictIdle()
+			if m.healthInterval > 0 {
+				m.checkHealth()
+			}
+		}
+	}
+}
+
+func (m *DBManager) evictIdle() {
+	if m.idleTimeout <= 0 {
+		return
 	}
 
-	m.connections[dbname] = db
-	return db, nil
+	deadline := time.Now().Add(-m.idleTimeout).UnixNano()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, pool := range m.connections {
+		if pool.lastUsed.Load() < deadline {
+			m.evict(key)
+		}
+	}
+}
+
+// checkHealth ping every pool outside of the lock, failing pools are reopened
+// with fresh credentials or dropped so next GetConnection retry
+func (m *DBManager) checkHealth() {
+	m.mu.RLock()
+	pools := make([]*tenantPool, 0, len(m.connections))
+	for _, pool := range m.connections {
+		//sessions of the shared pool have nothing to rebuild
+		if !pool.shared {
+			pools = append(pools, pool)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, pool := range pools {
+		ctx, cancel := context.WithTimeout(context.Background(), m.healthTimeout)
+		err := pool.sqlDB.PingContext(ctx)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		key := pool.key
+		fresh, err := m.openPool(context.Background(), pool.tenant)
+
+		m.mu.Lock()
+		current, exists := m.connections[key]
+		switch {
+		case !exists || current != pool:
+			//evicted or replaced meanwhile
+			if fresh != nil {
+				fresh.close()
+			}
+		case err != nil:
+			m.evict(key)
+		default:
+			fresh.lastUsed.Store(pool.lastUsed.Load())
+			m.connections[key] = fresh
+			m.retire(pool)
+		}
+		m.mu.Unlock()
+	}
 }
 
 // Close all connections before exit application
 func (m *DBManager) CloseConnections() error {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	var errs []error
-	for tenantID, db := range m.connections {
-		sqlDB, err := db.DB()
-		if err != nil {
+	for tenantID, pool := range m.connections {
+		if err := pool.close(); err != nil {
 			errs = append(errs, fmt.Errorf("tenant %s: %w", tenantID, err))
-			continue
 		}
-		if err := sqlDB.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("tenant %s: %w", tenantID, err))
+	}
+	for pool := range m.retired {
+		pool.close()
+	}
+
+	if m.shared != nil {
+		CloseReplicas(m.shared)
+		if sqlDB, err := m.shared.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("shared pool: %w", err))
+			}
 		}
+		m.shared = nil
 	}
 
+	m.connections = make(map[string]*tenantPool)
+	m.retired = make(map[*tenantPool]struct{})
+
 	if len(errs) > 0 {
 		return fmt.Errorf("errors closing connections: %v", errs)
 	}
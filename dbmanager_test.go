@@ -0,0 +1,375 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// newTestDBManager open pools lazily against closed port, nothing is dialed until ping
+func newTestDBManager(opens *int32, opts ...DBManagerOption) *DBManager {
+	m := NewDBManager(DBConfiguration{
+		DbType:         Postgresql,
+		Host:           "127.0.0.1",
+		Port:           "1",
+		Username:       "user",
+		ConnectTimeOut: 1,
+		MaxOpenConn:    10,
+		MaxIdleConn:    5,
+	}, opts...)
+
+	m.open = func(cfg DBConfiguration) (*gorm.DB, error) {
+		atomic.AddInt32(opens, 1)
+
+		conn, err := ConnectDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{DisableAutomaticPing: true})
+	}
+
+	return m
+}
+
+func tenantCtx(name string) context.Context {
+	return WithTenant(context.Background(), TenantInfo{DBName: name})
+}
+
+// closed pool refuse new connections before dialing
+func closedDB(db *gorm.DB) bool {
+	sqlDB, _ := db.DB()
+	_, err := sqlDB.Conn(context.Background())
+	return err != nil && err.Error() == "sql: database is closed"
+}
+
+func TestDBManagerMaxPools(t *testing.T) {
+	var opens int32
+	m := newTestDBManager(&opens, WithMaxPools(2), WithEvictionGrace(30*time.Millisecond))
+	defer m.CloseConnections()
+
+	db1, err := m.GetConnection(tenantCtx("db1"))
+	assert.Nil(t, err, "should nil")
+	db2, _ := m.GetConnection(tenantCtx("db2"))
+
+	again, _ := m.GetConnection(tenantCtx("db1"))
+	assert.Same(t, db1, again, "should reuse pool")
+
+	//db2 is least recently used
+	_, _ = m.GetConnection(tenantCtx("db3"))
+	assert.Len(t, m.connections, 2, "should keep max pools")
+	assert.False(t, closedDB(db2), "should keep evicted pool open during grace")
+	assert.Eventually(t, func() bool { return closedDB(db2) }, time.Second, 10*time.Millisecond, "should close evicted pool")
+	assert.False(t, closedDB(db1), "should keep recently used pool")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&opens), "should open three pools")
+}
+
+func TestDBManagerEvictionGrace(t *testing.T) {
+	dir := t.TempDir()
+	m := NewDBManager(DBConfiguration{DbType: Sqlite}, WithMaxPools(1), WithEvictionGrace(20*time.Millisecond))
+	defer m.CloseConnections()
+
+	db1, err := m.GetConnection(tenantCtx(filepath.Join(dir, "db1.db")))
+	require.Nil(t, err)
+	sqlDB, _ := db1.DB()
+	conn, err := sqlDB.Conn(context.Background())
+	require.Nil(t, err)
+
+	_, err = m.GetConnection(tenantCtx(filepath.Join(dir, "db2.db")))
+	require.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, conn.PingContext(context.Background()), "should keep pool with connection in use")
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return sqlDB.Ping() != nil
+	}, time.Second, 10*time.Millisecond, "should close pool once idle")
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	assert.Len(t, m.retired, 0, "should forget closed pool")
+}
+
+func TestDBManagerIdleTimeout(t *testing.T) {
+	var opens int32
+	m := newTestDBManager(&opens, WithIdleTimeout(40*time.Millisecond), WithEvictionGrace(10*time.Millisecond))
+	defer m.CloseConnections()
+
+	db, _ := m.GetConnection(tenantCtx("db1"))
+
+	assert.Eventually(t, func() bool {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return len(m.connections) == 0
+	}, time.Second, 10*time.Millisecond, "should evict idle pool")
+	assert.Eventually(t, func() bool { return closedDB(db) }, time.Second, 10*time.Millisecond, "should close idle pool")
+}
+
+func TestDBManagerHealthCheck(t *testing.T) {
+	var opens int32
+	m := newTestDBManager(&opens, WithHealthCheck(30*time.Millisecond, 500*time.Millisecond), WithEvictionGrace(10*time.Millisecond))
+	defer m.CloseConnections()
+
+	db, _ := m.GetConnection(tenantCtx("db1"))
+
+	//ping always fail on closed port, so broken pool is rebuilt
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&opens) > 1
+	}, 3*time.Second, 10*time.Millisecond, "should rebuild broken pool")
+
+	assert.Eventually(t, func() bool { return closedDB(db) }, time.Second, 10*time.Millisecond, "should close broken pool")
+
+	fresh, _ := m.GetConnection(tenantCtx("db1"))
+	assert.NotSame(t, db, fresh, "should serve rebuilt pool")
+}
+
+func TestDBManagerTenantPoolSize(t *testing.T) {
+	var opens int32
+	m := newTestDBManager(&opens, WithTenantPoolSize("big", 50, 20))
+	defer m.CloseConnections()
+
+	big, _ := m.GetConnection(tenantCtx("big"))
+	small, _ := m.GetConnection(tenantCtx("small"))
+
+	sqlBig, _ := big.DB()
+	sqlSmall, _ := small.DB()
+	assert.Equal(t, 50, sqlBig.Stats().MaxOpenConnections, "should override pool size")
+	assert.Equal(t, 10, sqlSmall.Stats().MaxOpenConnections, "should use base pool size")
+
+	assert.Nil(t, m.CloseConnections(), "should nil")
+	assert.True(t, closedDB(big), "should close all pools")
+}
+
+func TestDBManagerOpenGormDB(t *testing.T) {
+	//default factory is OpenGormDB, unreachable mysql fail on gorm ping
+	m := NewDBManager(DBConfiguration{
+		DbType:         Mysql,
+		Host:           "127.0.0.1",
+		Port:           "1",
+		Username:       "root",
+		ConnectTimeOut: 1,
+	})
+	defer m.CloseConnections()
+
+	_, err := m.GetConnection(tenantCtx("db1"))
+	assert.NotNil(t, err, "should error")
+	assert.Contains(t, err.Error(), "connection refused", "should dial the database")
+	assert.Len(t, m.connections, 0, "should not cache failed pool")
+}
+
+func TestDBManagerSqlite(t *testing.T) {
+	dir := t.TempDir()
+	m := NewDBManager(DBConfiguration{
+		DbType:  Sqlite,
+		Migrate: true,
+		Migrations: fstest.MapFS{
+			"0001_users.up.sql": {Data: []byte("CREATE TABLE schema_test_users (id INTEGER PRIMARY KEY, name TEXT);")},
+		},
+	})
+	defer m.CloseConnections()
+
+	db1, err := m.GetConnection(tenantCtx(filepath.Join(dir, "db1.db")))
+	assert.Nil(t, err, "should nil")
+	db2, err := m.GetConnection(tenantCtx(filepath.Join(dir, "db2.db")))
+	assert.Nil(t, err, "should nil")
+
+	assert.Nil(t, db1.Create(&schemaTestUser{Name: "john"}).Error, "should nil")
+
+	var count int64
+	assert.Nil(t, db2.Model(&schemaTestUser{}).Count(&count).Error, "should nil")
+	assert.Equal(t, int64(0), count, "should isolate tenant file")
+
+	assert.FileExists(t, filepath.Join(dir, "db1.db"), "should create tenant file")
+	assert.FileExists(t, filepath.Join(dir, "db2.db"), "should create tenant file")
+}
+
+type schemaTestUser struct {
+	ID   int64
+	Name string
+}
+
+// recordDriver accept every statement and log it with the connection running it
+type recordDriver struct {
+	mu    sync.Mutex
+	conns int
+	log   []recordedSQL
+}
+
+type recordedSQL struct {
+	conn int
+	sql  string
+}
+
+type recordConn struct {
+	d  *recordDriver
+	id int
+}
+
+type recordTx struct{ c *recordConn }
+
+type emptyRows struct{}
+
+func (d *recordDriver) Open(string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conns++
+	return &recordConn{d: d, id: d.conns}, nil
+}
+
+func (d *recordDriver) Connect(context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *recordDriver) Driver() driver.Driver {
+	return d
+}
+
+func (d *recordDriver) record(conn int, sql string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, recordedSQL{conn: conn, sql: sql})
+}
+
+// scope return statement run on the same connection right before the one containing sql
+func (d *recordDriver) scope(sql string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := len(d.log) - 1; i >= 0; i-- {
+		if !strings.Contains(d.log[i].sql, sql) {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if d.log[j].conn == d.log[i].conn {
+				return d.log[j].sql
+			}
+		}
+	}
+	return ""
+}
+
+func (c *recordConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *recordConn) Close() error { return nil }
+
+func (c *recordConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *recordConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.d.record(c.id, "BEGIN")
+	return recordTx{c: c}, nil
+}
+
+func (c *recordConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.d.record(c.id, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *recordConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.d.record(c.id, query)
+	return emptyRows{}, nil
+}
+
+func (tx recordTx) Commit() error {
+	tx.c.d.record(tx.c.id, "COMMIT")
+	return nil
+}
+
+func (tx recordTx) Rollback() error {
+	tx.c.d.record(tx.c.id, "ROLLBACK")
+	return nil
+}
+
+func (emptyRows) Columns() []string         { return []string{"id"} }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+func TestDBManagerSchemaPerTenant(t *testing.T) {
+	var opens int32
+	m := newTestDBManager(&opens, WithSchemaPerTenant())
+	defer m.CloseConnections()
+
+	rec := &recordDriver{}
+	m.open = func(cfg DBConfiguration) (*gorm.DB, error) {
+		atomic.AddInt32(&opens, 1)
+		return gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(rec)}), &gorm.Config{})
+	}
+
+	_, err := m.GetConnection(WithTenant(context.Background(), TenantInfo{ID: "a"}))
+	assert.ErrorIs(t, err, ErrInvalidTenant, "should require schema")
+
+	_, err = m.GetConnection(WithTenant(context.Background(), TenantInfo{Schema: `x"; DROP TABLE users; --`}))
+	assert.ErrorIs(t, err, ErrInvalidTenant, "should reject invalid schema")
+
+	_, err = m.GetConnection(WithTenant(context.Background(), TenantInfo{Schema: "TenantA"}))
+	assert.ErrorIs(t, err, ErrInvalidTenant, "should reject mixed case schema")
+
+	dbA, err := m.GetConnection(WithTenant(context.Background(), TenantInfo{Schema: "tenant_a"}))
+	require.Nil(t, err)
+	dbB, err := m.GetConnection(WithTenant(context.Background(), TenantInfo{Schema: "tenant_b"}))
+	require.Nil(t, err)
+
+	sqlA, _ := dbA.DB()
+	sqlB, _ := dbB.DB()
+	assert.Same(t, sqlA, sqlB, "should share one pool")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&opens), "should open shared pool once")
+
+	//TableName models, raw sql and Table() are not rewritten, search_path scope them
+	assert.Nil(t, dbA.Find(&[]OutboxMessage{}).Error, "should nil")
+	assert.Equal(t, `SET search_path TO "tenant_a"`, rec.scope(`FROM "outbox_messages"`), "should scope model")
+
+	assert.Nil(t, dbB.Exec("DELETE FROM schema_test_users").Error, "should nil")
+	assert.Equal(t, `SET search_path TO "tenant_b"`, rec.scope("DELETE FROM schema_test_users"), "should scope raw sql")
+
+	var ids []int64
+	assert.Nil(t, dbA.Table("schema_test_users").Pluck("id", &ids).Error, "should nil")
+	assert.Equal(t, `SET search_path TO "tenant_a"`, rec.scope(`SELECT "id" FROM "schema_test_users"`), "should scope table")
+
+	//pool picked by replica routing is scoped again
+	routed := dbA.Session(&gorm.Session{})
+	routed.Statement.ConnPool = sqlA
+	assert.Nil(t, routed.Raw("SELECT id FROM routed_users").Scan(&ids).Error, "should nil")
+	assert.Equal(t, `SET search_path TO "tenant_a"`, rec.scope("SELECT id FROM routed_users"), "should scope routed pool")
+
+	err = dbB.Transaction(func(tx *gorm.DB) error {
+		return tx.Exec("UPDATE schema_test_users SET name = 'x'").Error
+	})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, `SET LOCAL search_path TO "tenant_b"`, rec.scope("UPDATE schema_test_users"), "should scope transaction")
+
+	assert.Nil(t, m.CloseConnections(), "should nil")
+	assert.Eventually(t, func() bool { return sqlA.Ping() != nil }, time.Second, 10*time.Millisecond, "should close shared pool")
+
+	mysql := NewDBManager(DBConfiguration{DbType: Mysql, Username: "root"}, WithSchemaPerTenant())
+	defer mysql.CloseConnections()
+	_, err = mysql.GetConnection(WithTenant(context.Background(), TenantInfo{Schema: "tenant_a"}))
+	assert.NotNil(t, err, "should require postgres")
+}
+
+func TestProvisionTenant(t *testing.T) {
+	var opens int32
+	m := newTestDBManager(&opens)
+	defer m.CloseConnections()
+
+	err := m.ProvisionTenant(context.Background(), TenantInfo{Schema: "tenant_a"}, nil)
+	assert.NotNil(t, err, "should require schema mode")
+
+	err = ProvisionTenantSchema(context.Background(), nil, Mysql, "tenant_a", nil)
+	assert.NotNil(t, err, "should require postgres")
+}
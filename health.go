@@ -0,0 +1,283 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// DBHealth is result of ping and pool statistics of one sql.DB
+type DBHealth struct {
+	Status             string `json:"status"`
+	Error              string `json:"error,omitempty"`
+	LatencyMs          int64  `json:"latency_ms"`
+	MaxOpenConnections int    `json:"max_open_connections"`
+	OpenConnections    int    `json:"open_connections"`
+	InUse              int    `json:"in_use"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"wait_count"`
+	WaitDurationMs     int64  `json:"wait_duration_ms"`
+}
+
+func dbHealthFromStats(stats sql.DBStats) DBHealth {
+	return DBHealth{
+		Status:             HealthUp,
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+	}
+}
+
+// CheckDB ping db within timeout and report its pool statistics
+func CheckDB(ctx context.Context, db *sql.DB, timeout time.Duration) DBHealth {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := db.PingContext(ctx)
+	latency := time.Since(start)
+
+	//stats after ping so the ping connection is counted
+	health := dbHealthFromStats(db.Stats())
+	health.LatencyMs = latency.Milliseconds()
+	if err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	}
+
+	return health
+}
+
+// Stats return pool statistics of every open tenant pool keyed by tenant key,
+// tenants of schema per tenant mode report the shared pool
+func (m *DBManager) Stats() map[string]sql.DBStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make(map[string]sql.DBStats, len(m.connections))
+	for key, pool := range m.connections {
+		stats[key] = pool.sqlDB.Stats()
+	}
+	return stats
+}
+
+// Health ping every open tenant pool outside of the lock
+func (m *DBManager) Health(ctx context.Context, timeout time.Duration) map[string]DBHealth {
+	m.mu.RLock()
+	pools := make([]*tenantPool, 0, len(m.connections))
+	for _, pool := range m.connections {
+		pools = append(pools, pool)
+	}
+	m.mu.RUnlock()
+
+	result := make(map[string]DBHealth, len(pools))
+	for _, pool := range pools {
+		result[pool.key] = CheckDB(ctx, pool.sqlDB, timeout)
+	}
+	return result
+}
+
+type HealthCheckFunc func(ctx context.Context) (details interface{}, err error)
+
+type HealthResult struct {
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	LatencyMs int64       `json:"latency_ms"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]HealthResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheckFunc
+}
+
+// HealthChecker run registered checks concurrently, report is up only when every check is up
+type HealthChecker struct {
+	timeout time.Duration
+	checks  []namedCheck
+}
+
+type HealthOption func(*HealthChecker)
+
+// WithHealthTimeout bound every check, default 3 seconds
+func WithHealthTimeout(d time.Duration) HealthOption {
+	return func(h *HealthChecker) {
+		h.timeout = d
+	}
+}
+
+func WithCheck(name string, check HealthCheckFunc) HealthOption {
+	return func(h *HealthChecker) {
+		h.checks = append(h.checks, namedCheck{name: name, check: check})
+	}
+}
+
+// WithDBCheck ping db and report its pool statistics
+func WithDBCheck(name string, db *sql.DB) HealthOption {
+	return WithCheck(name, func(ctx context.Context) (interface{}, error) {
+		health := CheckDB(ctx, db, 0)
+		if health.Status != HealthUp {
+			return health, fmt.Errorf("fail ping database : %s", health.Error)
+		}
+		return health, nil
+	})
+}
+
+// WithDBManagerCheck ping every open tenant pool, check is down when any tenant is down
+func WithDBManagerCheck(name string, m *DBManager) HealthOption {
+	return WithCheck(name, func(ctx context.Context) (interface{}, error) {
+		tenants := m.Health(ctx, 0)
+
+		var down []string
+		for key, health := range tenants {
+			if health.Status != HealthUp {
+				down = append(down, key)
+			}
+		}
+
+		if len(down) > 0 {
+			sort.Strings(down)
+			return tenants, fmt.Errorf("tenant database down : %s", strings.Join(down, ", "))
+		}
+		return tenants, nil
+	})
+}
+
+func WithCacherCheck(name string, c CacherV2) HealthOption {
+	return WithCheck(name, func(ctx context.Context) (interface{}, error) {
+		return nil, c.PingCtx(ctx)
+	})
+}
+
+// WithRabbitMQCheck ping r when it implement Ping() error, such as NewRabbitMQ client,
+// otherwise the check is always up
+func WithRabbitMQCheck(name string, r RabbitMQ) HealthOption {
+	return WithCheck(name, func(ctx context.Context) (interface{}, error) {
+		if p, ok := r.(interface{ Ping() error }); ok {
+			return nil, p.Ping()
+		}
+		return nil, nil
+	})
+}
+
+func NewHealthChecker(opts ...HealthOption) *HealthChecker {
+	h := &HealthChecker{timeout: 3 * time.Second}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *HealthChecker) Check(ctx context.Context) HealthReport {
+	report := HealthReport{Status: HealthUp, Checks: make(map[string]HealthResult, len(h.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			result := h.run(ctx, c.check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if result.Status != HealthUp {
+				report.Status = HealthDown
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// run give up on check not returning in time, it keep running in background
+func (h *HealthChecker) run(ctx context.Context, check HealthCheckFunc) HealthResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	type outcome struct {
+		details interface{}
+		err     error
+	}
+	done := make(chan outcome, 1)
+
+	start := time.Now()
+	go func() {
+		details, err := check(ctx)
+		done <- outcome{details: details, err: err}
+	}()
+
+	result := HealthResult{Status: HealthUp}
+	select {
+	case o := <-done:
+		result.Details = o.details
+		if o.err != nil {
+			result.Status = HealthDown
+			result.Error = o.err.Error()
+		}
+	case <-ctx.Done():
+		result.Status = HealthDown
+		result.Error = fmt.Sprintf("health check timeout : %v", ctx.Err())
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+
+	return result
+}
+
+func healthStatusCode(report HealthReport) int {
+	if report.Status == HealthUp {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+/*
+GinHealthz respond report as json, 503 when any check is down
+example :
+
+	r.GET("/healthz", tools.GinHealthz(tools.NewHealthChecker(
+		tools.WithDBCheck("database", sqlDB),
+		tools.WithCacherCheck("redis", cacher),
+		tools.WithRabbitMQCheck("rabbitmq", mq),
+	)))
+*/
+func GinHealthz(h *HealthChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := h.Check(c.Request.Context())
+		c.JSON(healthStatusCode(report), report)
+	}
+}
+
+// FiberHealthz respond report as json, 503 when any check is down
+func FiberHealthz(h *HealthChecker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := h.Check(c.UserContext())
+		return c.Status(healthStatusCode(report)).JSON(report)
+	}
+}
@@ -0,0 +1,124 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDB(t *testing.T) {
+	conn, err := ConnectDB(DBConfiguration{DbType: Postgresql, Host: "127.0.0.1", Port: "1", MaxOpenConn: 7})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	health := CheckDB(context.Background(), conn, time.Second)
+	assert.Equal(t, HealthDown, health.Status, "should be down")
+	assert.Contains(t, health.Error, "connection refused", "should report error")
+	assert.Equal(t, 7, health.MaxOpenConnections, "should report pool stats")
+}
+
+func TestDBManagerHealth(t *testing.T) {
+	var opens int32
+	m := newTestDBManager(&opens)
+	defer m.CloseConnections()
+
+	_, err := m.GetConnection(tenantCtx("db1"))
+	require.Nil(t, err)
+	_, err = m.GetConnection(tenantCtx("db2"))
+	require.Nil(t, err)
+
+	stats := m.Stats()
+	assert.Len(t, stats, 2, "should report every tenant")
+	assert.Equal(t, 10, stats["db1"].MaxOpenConnections, "should report pool stats")
+
+	health := m.Health(context.Background(), time.Second)
+	assert.Len(t, health, 2, "should ping every tenant")
+	assert.Equal(t, HealthDown, health["db2"].Status, "should be down")
+}
+
+// noPingRabbitMQ is RabbitMQ implementation without Ping
+type noPingRabbitMQ struct {
+	RabbitMQ
+}
+
+func TestHealthChecker(t *testing.T) {
+	mq := &MockRabbitMQ{}
+	mq.On("Ping").Return(nil)
+
+	h := NewHealthChecker(
+		WithCacherCheck("redis", NewFakeCacher("health", 60)),
+		WithRabbitMQCheck("rabbitmq", mq),
+		WithCheck("custom", func(ctx context.Context) (interface{}, error) {
+			return map[string]int{"queued": 3}, nil
+		}),
+	)
+
+	report := h.Check(context.Background())
+	assert.Equal(t, HealthUp, report.Status, "should be up")
+	assert.Len(t, report.Checks, 3, "should run every check")
+
+	noPing := NewHealthChecker(WithRabbitMQCheck("rabbitmq", noPingRabbitMQ{})).Check(context.Background())
+	assert.Equal(t, HealthUp, noPing.Status, "should skip ping when not supported")
+	assert.Equal(t, map[string]int{"queued": 3}, report.Checks["custom"].Details, "should keep details")
+	mq.AssertExpectations(t)
+
+	down := &MockRabbitMQ{}
+	down.On("Ping").Return(errors.New("rabbitmq connection is closed"))
+
+	h = NewHealthChecker(
+		WithHealthTimeout(20*time.Millisecond),
+		WithRabbitMQCheck("rabbitmq", down),
+		WithCheck("slow", func(ctx context.Context) (interface{}, error) {
+			time.Sleep(200 * time.Millisecond)
+			return nil, nil
+		}),
+	)
+
+	report = h.Check(context.Background())
+	assert.Equal(t, HealthDown, report.Status, "should be down")
+	assert.Equal(t, "rabbitmq connection is closed", report.Checks["rabbitmq"].Error, "should report error")
+	assert.Contains(t, report.Checks["slow"].Error, "timeout", "should time out")
+}
+
+func TestHealthzHandlers(t *testing.T) {
+	fail := false
+	h := NewHealthChecker(WithCheck("db", func(ctx context.Context) (interface{}, error) {
+		if fail {
+			return nil, errors.New("boom")
+		}
+		return nil, nil
+	}))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/healthz", GinHealthz(h))
+
+	app := fiber.New()
+	app.Get("/healthz", FiberHealthz(h))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "should be ok")
+
+	var report HealthReport
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report), "should nil")
+	assert.Equal(t, HealthUp, report.Checks["db"].Status, "should report check")
+
+	fail = true
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "should be unavailable")
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "should be unavailable")
+}
@@ -0,0 +1,213 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("lock not acquired")
+	ErrLockNotHeld     = errors.New("lock not held")
+)
+
+// redis expire in milliseconds and lease is renewed every ttl/3
+const minLockTTL = 3 * time.Millisecond
+
+// delete the key only when it still hold our token
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// extend the key only when it still hold our token
+var refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+type LockerOption func(*Locker)
+
+// WithLockRetry set backoff between attempts of Lock, wait start from min and double up to max,
+// min must be positive
+func WithLockRetry(min, max time.Duration) LockerOption {
+	return func(l *Locker) {
+		l.retryMin = min
+		l.retryMax = max
+	}
+}
+
+// Locker provide mutual exclusion across replicas using redis
+type Locker struct {
+	rdb      redis.UniversalClient
+	prefix   string
+	ttl      time.Duration
+	retryMin time.Duration
+	retryMax time.Duration
+}
+
+// NewLocker create locker, ttl is the lease of each lock before it expire by itself
+func NewLocker(rdb redis.UniversalClient, prefix string, ttl time.Duration, opts ...LockerOption) (*Locker, error) {
+	if ttl < minLockTTL {
+		return nil, fmt.Errorf("lock ttl must be at least %s, got %s", minLockTTL, ttl)
+	}
+
+	l := &Locker{
+		rdb:      rdb,
+		prefix:   prefix,
+		ttl:      ttl,
+		retryMin: 50 * time.Millisecond,
+		retryMax: time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.retryMin <= 0 {
+		return nil, fmt.Errorf("lock retry interval must be positive, got %s", l.retryMin)
+	}
+	if l.retryMax < l.retryMin {
+		l.retryMax = l.retryMin
+	}
+
+	return l, nil
+}
+
+// Lock is a held lease, release it with Unlock
+type Lock struct {
+	locker *Locker
+	key    string
+	token  string
+}
+
+func (l *Locker) key(name string) string {
+	return l.prefix + "_lock_" + name
+}
+
+// TryLock acquire lock once, return ErrLockNotAcquired when it is held by someone else
+func (l *Locker) TryLock(ctx context.Context, name string) (*Lock, error) {
+	lock := &Lock{
+		locker: l,
+		key:    l.key(name),
+		token:  NewUUID(),
+	}
+
+	ok, err := l.rdb.SetNX(ctx, lock.key, lock.token, l.ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("fail acquire lock %s : %w", name, err)
+	}
+
+	if !ok {
+		return nil, ErrLockNotAcquired
+	}
+
+	return lock, nil
+}
+
+// Lock wait until lock is acquired or ctx is done
+func (l *Locker) Lock(ctx context.Context, name string) (*Lock, error) {
+	wait := l.retryMin
+	for {
+		lock, err := l.TryLock(ctx, name)
+		if !errors.Is(err, ErrLockNotAcquired) {
+			return lock, err
+		}
+
+		//random jitter avoid replicas retrying in lockstep
+		timer := time.NewTimer(wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		wait *= 2
+		if wait > l.retryMax {
+			wait = l.retryMax
+		}
+	}
+}
+
+// WithLock run fn while holding the lock, the lease is renewed in background
+// until fn return. ctx passed to fn is canceled when the lease is lost.
+func (l *Locker) WithLock(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	lock, err := l.Lock(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lock.Refresh(workCtx); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = fn(workCtx)
+	close(done)
+
+	//release even when ctx is already canceled, ErrLockNotHeld here means
+	//the lease was lost while fn was running
+	if uerr := lock.Unlock(context.WithoutCancel(ctx)); uerr != nil && err == nil {
+		err = uerr
+	}
+
+	return err
+}
+
+// Token return random value identifying this lease
+func (lk *Lock) Token() string {
+	return lk.token
+}
+
+// Refresh extend the lease by locker ttl
+func (lk *Lock) Refresh(ctx context.Context) error {
+	res, err := refreshScript.Run(ctx, lk.locker.rdb, []string{lk.key}, lk.token, lk.locker.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("fail refresh lock : %w", err)
+	}
+
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// Unlock release the lease, return ErrLockNotHeld when it already expired or taken over
+func (lk *Lock) Unlock(ctx context.Context) error {
+	res, err := unlockScript.Run(ctx, lk.locker.rdb, []string{lk.key}, lk.token).Int64()
+	if err != nil {
+		return fmt.Errorf("fail release lock : %w", err)
+	}
+
+	if res == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocker(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	l, err := NewLocker(rds, "test", time.Second, WithLockRetry(10*time.Millisecond, 50*time.Millisecond))
+	require.Nil(t, err)
+
+	lock, err := l.TryLock(ctx, "job")
+	assert.Nil(t, err, "should acquire lock")
+	assert.NotEmpty(t, lock.Token(), "should have token")
+
+	//second attempt fail while lock is held
+	_, err = l.TryLock(ctx, "job")
+	assert.ErrorIs(t, err, ErrLockNotAcquired, "should not acquire held lock")
+
+	//lock with deadline give up
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = l.Lock(short, "job")
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "should wait until deadline")
+
+	//refresh extend lease
+	assert.Nil(t, lock.Refresh(ctx), "should refresh own lock")
+
+	//other token can not release the lock
+	stolen := &Lock{locker: l, key: lock.key, token: "other"}
+	assert.ErrorIs(t, stolen.Unlock(ctx), ErrLockNotHeld, "should not release foreign lock")
+
+	//lock wait until released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Unlock(ctx)
+	}()
+	next, err := l.Lock(ctx, "job")
+	assert.Nil(t, err, "should acquire after release")
+	assert.Nil(t, next.Unlock(ctx), "should release")
+	assert.ErrorIs(t, next.Unlock(ctx), ErrLockNotHeld, "double release should fail")
+}
+
+func TestNewLockerValidation(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	_, err = NewLocker(rds, "test", time.Nanosecond)
+	assert.NotNil(t, err, "should reject tiny ttl")
+
+	_, err = NewLocker(rds, "test", time.Second, WithLockRetry(0, time.Second))
+	assert.NotNil(t, err, "should reject zero retry interval")
+}
+
+func TestLockerWithLock(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.Nil(t, err)
+	defer mr.Close()
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	ctx := context.Background()
+	l, err := NewLocker(rds, "test", 300*time.Millisecond)
+	require.Nil(t, err)
+
+	ran := false
+	err = l.WithLock(ctx, "job", func(ctx context.Context) error {
+		ran = true
+
+		//lock is held while work running
+		_, err := l.TryLock(ctx, "job")
+		assert.ErrorIs(t, err, ErrLockNotAcquired, "lock should be held")
+
+		//miniredis expire only on fast forward, move past the ttl in total,
+		//each step leave renewed lease alive
+		for i := 0; i < 3; i++ {
+			time.Sleep(150 * time.Millisecond)
+			mr.FastForward(200 * time.Millisecond)
+			assert.True(t, mr.Exists(l.key("job")), "lease should be renewed")
+		}
+		assert.Nil(t, ctx.Err(), "lease should be renewed")
+		return nil
+	})
+	assert.Nil(t, err, "should nil")
+	assert.True(t, ran, "work should run")
+
+	//released after work
+	lock, err := l.TryLock(ctx, "job")
+	assert.Nil(t, err, "lock should be released")
+	lock.Unlock(ctx)
+}
@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+type traceIDCtxKey struct{}
+
+// ContextWithTraceID return context carrying trace id, it is logged with every query
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDCtxKey{}, traceID)
+}
+
+func TraceIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(traceIDCtxKey{}).(string)
+	return id
+}
+
+type ZapGormLoggerConfig struct {
+	SlowThreshold             time.Duration                    //default 1 second, negative disable slow query log
+	LogLevel                  logger.LogLevel                  //default logger.Warn, logger.Info log every query
+	IgnoreRecordNotFoundError bool                             //do not log gorm.ErrRecordNotFound
+	ShowParams                bool                             //bind parameters are logged as placeholders unless set
+	TraceID                   func(ctx context.Context) string //default TraceIDFromContext
+}
+
+// NewZapGormLogger adapt zap to gorm logger, queries are logged with sql, rows,
+// elapsed, caller and trace_id fields
+func NewZapGormLogger(l *zap.Logger, cfg ZapGormLoggerConfig) logger.Interface {
+	if cfg.SlowThreshold == 0 {
+		cfg.SlowThreshold = time.Second
+	}
+	if cfg.LogLevel == 0 {
+		cfg.LogLevel = logger.Warn
+	}
+	if cfg.TraceID == nil {
+		cfg.TraceID = TraceIDFromContext
+	}
+
+	//caller of the query is added as field, zap caller would point here
+	return &zapGormLogger{log: l.WithOptions(zap.WithCaller(false)), cfg: cfg}
+}
+
+type zapGormLogger struct {
+	log *zap.Logger
+	cfg ZapGormLoggerConfig
+}
+
+func (l *zapGormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	clone := *l
+	clone.cfg.LogLevel = level
+	return &clone
+}
+
+func (l *zapGormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.cfg.LogLevel >= logger.Info {
+		l.log.Info(fmt.Sprintf(msg, data...), l.fields(ctx, utils.FileWithLineNum())...)
+	}
+}
+
+func (l *zapGormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.cfg.LogLevel >= logger.Warn {
+		l.log.Warn(fmt.Sprintf(msg, data...), l.fields(ctx, utils.FileWithLineNum())...)
+	}
+}
+
+func (l *zapGormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.cfg.LogLevel >= logger.Error {
+		l.log.Error(fmt.Sprintf(msg, data...), l.fields(ctx, utils.FileWithLineNum())...)
+	}
+}
+
+func (l *zapGormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.cfg.LogLevel <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	caller := utils.FileWithLineNum()
+
+	query := func() []zap.Field {
+		sql, rows := fc()
+		return append(l.fields(ctx, caller),
+			zap.String("sql", sql),
+			zap.Int64("rows", rows),
+			zap.Duration("elapsed", elapsed),
+		)
+	}
+
+	switch {
+	case err != nil && l.cfg.LogLevel >= logger.Error && !(l.cfg.IgnoreRecordNotFoundError && errors.Is(err, gorm.ErrRecordNotFound)):
+		l.log.Error("query error", append(query(), zap.Error(err))...)
+	case l.cfg.SlowThreshold > 0 && elapsed > l.cfg.SlowThreshold && l.cfg.LogLevel >= logger.Warn:
+		l.log.Warn("slow query", append(query(), zap.Duration("threshold", l.cfg.SlowThreshold))...)
+	case l.cfg.LogLevel >= logger.Info:
+		l.log.Info("query", query()...)
+	}
+}
+
+// ParamsFilter drop bind parameters so values never reach the logs
+func (l *zapGormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.cfg.ShowParams {
+		return sql, params
+	}
+	return sql, nil
+}
+
+func (l *zapGormLogger) fields(ctx context.Context, caller string) []zap.Field {
+	fields := []zap.Field{zap.String("caller", caller)}
+	if id := l.cfg.TraceID(ctx); id != "" {
+		fields = append(fields, zap.String("trace_id", id))
+	}
+	return fields
+}
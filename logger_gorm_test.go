@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type gormLoggerTestUser struct {
+	ID   int64
+	Name string
+}
+
+func TestZapGormLoggerQuery(t *testing.T) {
+	logs, obs := MockLogs()
+
+	conn, err := ConnectDB(DBConfiguration{DbType: Postgresql, Host: "127.0.0.1", Port: "1"})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	open := func(showParams bool) *gorm.DB {
+		db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+			DisableAutomaticPing: true,
+			DryRun:               true,
+			Logger:               gormLogger(DBConfiguration{Logger: logs, Logging: true, LogSQLParams: showParams}),
+		})
+		require.Nil(t, err)
+		return db
+	}
+
+	ctx := ContextWithTraceID(context.Background(), "trace-1")
+	open(false).WithContext(ctx).Where("name = ?", "secret").Find(&[]gormLoggerTestUser{})
+
+	require.Equal(t, 1, obs.Len())
+	entry := obs.All()[0]
+	fields := entry.ContextMap()
+	assert.Equal(t, "query", entry.Message, "should log query")
+	assert.Equal(t, "trace-1", fields["trace_id"], "should log trace id")
+	assert.Contains(t, fields["sql"], "$1", "should keep placeholder")
+	assert.NotContains(t, fields["sql"], "secret", "should redact params")
+	assert.True(t, strings.Contains(fields["caller"].(string), "logger_gorm_test.go"), "should log caller")
+	assert.Contains(t, fields, "rows", "should log rows")
+	assert.Contains(t, fields, "elapsed", "should log elapsed")
+
+	open(true).Where("name = ?", "secret").Find(&[]gormLoggerTestUser{})
+	assert.Contains(t, obs.All()[1].ContextMap()["sql"], "secret", "should show params")
+}
+
+func TestZapGormLoggerTrace(t *testing.T) {
+	logs, obs := MockLogs()
+	l := NewZapGormLogger(logs, ZapGormLoggerConfig{SlowThreshold: 10 * time.Millisecond, IgnoreRecordNotFoundError: true})
+	fc := func() (string, int64) { return "SELECT 1", 1 }
+
+	//default warn level skip fast queries
+	l.Trace(context.Background(), time.Now(), fc, nil)
+	assert.Equal(t, 0, obs.Len(), "should skip fast query")
+
+	l.Trace(context.Background(), time.Now().Add(-time.Second), fc, nil)
+	require.Equal(t, 1, obs.Len())
+	assert.Equal(t, "slow query", obs.All()[0].Message, "should log slow query")
+
+	l.Trace(context.Background(), time.Now(), fc, errors.New("boom"))
+	require.Equal(t, 2, obs.Len())
+	assert.Equal(t, "query error", obs.All()[1].Message, "should log error")
+
+	l.Trace(context.Background(), time.Now(), fc, gorm.ErrRecordNotFound)
+	assert.Equal(t, 2, obs.Len(), "should ignore record not found")
+
+	l.LogMode(logger.Silent).Trace(context.Background(), time.Now(), fc, errors.New("boom"))
+	assert.Equal(t, 2, obs.Len(), "should be silent")
+}
@@ -0,0 +1,445 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migration files are named <version>_<name>.up.sql and <version>_<name>.down.sql
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+const defaultMigrationTable = "schema_migrations"
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigratorOption func(*Migrator)
+
+// WithMigrationTable change table used to track applied versions
+func WithMigrationTable(name string) MigratorOption {
+	return func(m *Migrator) {
+		m.table = name
+	}
+}
+
+// WithMigrationSchema run migrations with postgres search_path set to schema,
+// tracking table is then created inside that schema
+func WithMigrationSchema(schema string) MigratorOption {
+	return func(m *Migrator) {
+		m.schema = schema
+	}
+}
+
+// WithDryRun only report what would be applied or rolled back
+func WithDryRun() MigratorOption {
+	return func(m *Migrator) {
+		m.dryRun = true
+	}
+}
+
+// Migrator apply versioned sql files from fs.FS, only one replica migrate
+// at a time thanks to database advisory lock.
+//
+// MySQL commit DDL implicitly, so a file failing half way can't be rolled back.
+// The version is then left dirty in the tracking table and every run refuse to
+// continue until it is resolved by hand: finish the migration and set dirty to
+// false, or undo its partial changes and delete the row.
+type Migrator struct {
+	db     *sql.DB
+	dbType string
+	fsys   fs.FS
+	table  string
+	schema string
+	dryRun bool
+}
+
+func NewMigrator(db *sql.DB, dbType string, fsys fs.FS, opts ...MigratorOption) *Migrator {
+	m := &Migrator{
+		db:     db,
+		dbType: dbType,
+		fsys:   fsys,
+		table:  defaultMigrationTable,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Migrate apply every pending migration found in dir
+func Migrate(ctx context.Context, db *sql.DB, dbType string, dir fs.FS) error {
+	_, err := NewMigrator(db, dbType, dir).Up(ctx)
+	return err
+}
+
+// Rollback revert last n applied migrations found in dir
+func Rollback(ctx context.Context, db *sql.DB, dbType string, dir fs.FS, n int) error {
+	_, err := NewMigrator(db, dbType, dir).Rollback(ctx, n)
+	return err
+}
+
+// LoadMigrations read migration files from root of fsys sorted by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("fail read migrations : %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("fail read migration %s : %w", entry.Name(), err)
+		}
+
+		m, exist := byVersion[version]
+		if !exist {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up apply pending migrations and return them, in dry run nothing is executed
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	migrations, err := LoadMigrations(m.fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range migrations {
+			if !applied[mg.Version] {
+				pending = append(pending, mg)
+			}
+		}
+
+		if m.dryRun {
+			return nil
+		}
+
+		insert := fmt.Sprintf("INSERT INTO %s (version, name, applied_at, dirty) VALUES (%s, %s, %s, %s)",
+			m.table, m.placeholder(1), m.placeholder(2), m.placeholder(3), m.placeholder(4))
+		clean := fmt.Sprintf("UPDATE %s SET dirty = FALSE WHERE version = %s", m.table, m.placeholder(1))
+
+		for _, mg := range pending {
+			now := time.Now().UTC()
+			if m.dbType == Mysql {
+				if _, err := conn.ExecContext(ctx, insert, mg.Version, mg.Name, now, true); err != nil {
+					return fmt.Errorf("fail mark migration %d_%s dirty : %w", mg.Version, mg.Name, err)
+				}
+				err = m.run(ctx, conn, mg.Up, clean, mg.Version)
+			} else {
+				err = m.run(ctx, conn, mg.Up, insert, mg.Version, mg.Name, now, false)
+			}
+			if err != nil {
+				return fmt.Errorf("fail apply migration %d_%s : %w", mg.Version, mg.Name, err)
+			}
+		}
+
+		return nil
+	})
+
+	return pending, err
+}
+
+// Rollback revert last n applied migrations and return them, in dry run nothing is executed
+func (m *Migrator) Rollback(ctx context.Context, n int) ([]Migration, error) {
+	migrations, err := LoadMigrations(m.fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			if !applied[migrations[i].Version] {
+				continue
+			}
+			if migrations[i].Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", migrations[i].Version, migrations[i].Name)
+			}
+			reverted = append(reverted, migrations[i])
+		}
+
+		if m.dryRun {
+			return nil
+		}
+
+		dirty := fmt.Sprintf("UPDATE %s SET dirty = TRUE WHERE version = %s", m.table, m.placeholder(1))
+		remove := fmt.Sprintf("DELETE FROM %s WHERE version = %s", m.table, m.placeholder(1))
+
+		for _, mg := range reverted {
+			if m.dbType == Mysql {
+				if _, err := conn.ExecContext(ctx, dirty, mg.Version); err != nil {
+					return fmt.Errorf("fail mark migration %d_%s dirty : %w", mg.Version, mg.Name, err)
+				}
+			}
+			if err := m.run(ctx, conn, mg.Down, remove, mg.Version); err != nil {
+				return fmt.Errorf("fail rollback migration %d_%s : %w", mg.Version, mg.Name, err)
+			}
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// run migration body and bookkeeping statement in one transaction, on mysql
+// the caller flag the version dirty first as DDL commit on its own
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, body, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements(body) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mysql driver reject multiple statements in one Exec, so split the file
+func (m *Migrator) statements(body string) []string {
+	if m.dbType != Mysql {
+		return []string{body}
+	}
+	return splitSQLStatements(body)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]bool, error) {
+	applied := map[int64]bool{}
+
+	if !m.dryRun {
+		create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL, dirty BOOLEAN NOT NULL DEFAULT FALSE)", m.table)
+		if _, err := conn.ExecContext(ctx, create); err != nil {
+			return nil, fmt.Errorf("fail create migration table : %w", err)
+		}
+
+		//table created before dirty tracking
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SELECT dirty FROM %s WHERE 1 = 0", m.table)); err != nil {
+			alter := fmt.Sprintf("ALTER TABLE %s ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE", m.table)
+			if _, err := conn.ExecContext(ctx, alter); err != nil {
+				return nil, fmt.Errorf("fail add dirty column to migration table : %w", err)
+			}
+		}
+	}
+
+	if err := m.checkDirty(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s", m.table))
+	if err != nil {
+		//in dry run the table may not exist yet
+		if m.dryRun {
+			return applied, nil
+		}
+		return nil, fmt.Errorf("fail read applied migrations : %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// checkDirty refuse to continue while a migration failed half way
+func (m *Migrator) checkDirty(ctx context.Context, conn *sql.Conn) error {
+	var version int64
+	var name string
+	err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT version, name FROM %s WHERE dirty = TRUE ORDER BY version LIMIT 1", m.table)).Scan(&version, &name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		//in dry run the table may not exist yet or predate dirty tracking
+		if m.dryRun {
+			return nil
+		}
+		return fmt.Errorf("fail read dirty migrations : %w", err)
+	}
+
+	return fmt.Errorf("migration %d_%s is dirty, a previous run failed half way : resolve it in %s before migrating", version, name, m.table)
+}
+
+func (m *Migrator) placeholder(i int) string {
+	if m.dbType == Postgresql {
+		return "$" + strconv.Itoa(i)
+	}
+	return "?"
+}
+
+// withLock hold database advisory lock on single connection while fn run
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("fail get connection : %w", err)
+	}
+	defer conn.Close()
+
+	if m.schema != "" {
+		if m.dbType != Postgresql {
+			return fmt.Errorf("migration schema is only supported on postgres")
+		}
+		if _, err := conn.ExecContext(ctx, "SET search_path TO "+quoteIdentifier(m.schema)); err != nil {
+			return fmt.Errorf("fail set search_path : %w", err)
+		}
+		//connection go back to the pool, so restore default path
+		defer conn.ExecContext(context.WithoutCancel(ctx), "RESET search_path")
+	}
+
+	lockID := int64(crc32.ChecksumIEEE([]byte("tools:" + m.schema + "." + m.table)))
+
+	switch m.dbType {
+	case Postgresql:
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+			return fmt.Errorf("fail acquire migration lock : %w", err)
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockID)
+	case Mysql:
+		var got sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", strconv.FormatInt(lockID, 10), 600).Scan(&got)
+		if err != nil {
+			return fmt.Errorf("fail acquire migration lock : %w", err)
+		}
+		if got.Int64 != 1 {
+			return fmt.Errorf("fail acquire migration lock : timeout")
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", strconv.FormatInt(lockID, 10))
+	case Sqlite:
+		//migration transactions take the database write lock, nothing else to hold
+	}
+
+	return fn(conn)
+}
+
+// splitSQLStatements split on ';' outside of quotes and comments
+func splitSQLStatements(body string) []string {
+	var stmts []string
+	var cur strings.Builder
+	var quote byte
+	lineComment, blockComment := false, false
+
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		next := byte(0)
+		if i+1 < len(body) {
+			next = body[i+1]
+		}
+
+		switch {
+		case lineComment:
+			if ch != '\n' {
+				continue
+			}
+			lineComment = false
+		case blockComment:
+			if ch == '*' && next == '/' {
+				blockComment = false
+				cur.WriteByte(ch)
+				i++
+				ch = next
+			}
+		case quote != 0:
+			if ch == '\\' && next != 0 {
+				cur.WriteByte(ch)
+				i++
+				ch = next
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '-' && next == '-', ch == '#':
+			lineComment = true
+			continue
+		case ch == '/' && next == '*':
+			blockComment = true
+		case ch == ';':
+			if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			cur.Reset()
+			continue
+		}
+
+		cur.WriteByte(ch)
+	}
+
+	if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+
+	return stmts
+}
@@ -0,0 +1,134 @@
+package tools
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email TEXT;")},
+		"0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email;")},
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"README.md":                  {Data: []byte("ignored")},
+	}
+
+	migrations, err := LoadMigrations(fsys)
+	assert.Nil(t, err, "should nil")
+	assert.Len(t, migrations, 2, "should load two migrations")
+	assert.Equal(t, int64(1), migrations[0].Version, "should sorted by version")
+	assert.Equal(t, "create_users", migrations[0].Name, "should parse name")
+	assert.Equal(t, "DROP TABLE users;", migrations[0].Down, "should load down file")
+	assert.Equal(t, int64(2), migrations[1].Version, "should sorted by version")
+
+	//down without up
+	_, err = LoadMigrations(fstest.MapFS{
+		"0001_x.down.sql": {Data: []byte("DROP TABLE x;")},
+	})
+	assert.NotNil(t, err, "should error without up file")
+
+	//same version used twice
+	_, err = LoadMigrations(fstest.MapFS{
+		"0001_a.up.sql": {Data: []byte("SELECT 1;")},
+		"0001_b.up.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.NotNil(t, err, "should error on duplicate version")
+}
+
+func TestSplitSQLStatements(t *testing.T) {
+	body := `
+-- comment; with semicolon
+CREATE TABLE a (name VARCHAR(10) DEFAULT 'x;y');
+/* block; comment */
+INSERT INTO a VALUES ("it\"s;");
+# mysql comment;
+DROP TABLE ` + "`b;c`" + `
+`
+
+	stmts := splitSQLStatements(body)
+	assert.Equal(t, []string{
+		"CREATE TABLE a (name VARCHAR(10) DEFAULT 'x;y')",
+		"/* block; comment */\nINSERT INTO a VALUES (\"it\\\"s;\")",
+		"DROP TABLE `b;c`",
+	}, stmts, "should split outside quotes and comments")
+}
+
+func TestMigrateSqlite(t *testing.T) {
+	db, err := ConnectDB(DBConfiguration{DbType: Sqlite})
+	require.Nil(t, err)
+	defer db.Close()
+
+	fsys := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);\nCREATE INDEX users_id ON users (id);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email TEXT;")},
+		"0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email;")},
+	}
+
+	m := NewMigrator(db, Sqlite, fsys)
+	applied, err := m.Up(context.Background())
+	assert.Nil(t, err, "should nil")
+	assert.Len(t, applied, 2, "should apply every migration")
+
+	_, err = db.Exec("INSERT INTO users (id, email) VALUES (1, 'a@b.c')")
+	assert.Nil(t, err, "should run every statement")
+
+	applied, err = m.Up(context.Background())
+	assert.Nil(t, err, "should nil")
+	assert.Len(t, applied, 0, "should skip applied migrations")
+
+	reverted, err := m.Rollback(context.Background(), 1)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(2), reverted[0].Version, "should revert last migration")
+
+	_, err = db.Exec("INSERT INTO users (id, email) VALUES (2, 'a@b.c')")
+	assert.NotNil(t, err, "should drop column")
+}
+
+func TestMigrateDirty(t *testing.T) {
+	db, err := ConnectDB(DBConfiguration{DbType: Sqlite})
+	require.Nil(t, err)
+	defer db.Close()
+
+	//tracking table created before dirty tracking
+	_, err = db.Exec("CREATE TABLE schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)")
+	require.Nil(t, err)
+
+	fsys := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"0002_broken.up.sql":         {Data: []byte("CREATE TABLE broken (;")},
+	}
+
+	m := NewMigrator(db, Sqlite, fsys)
+	_, err = m.Up(context.Background())
+	assert.NotNil(t, err, "should fail broken migration")
+
+	var dirty int
+	err = db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE dirty = TRUE").Scan(&dirty)
+	assert.Nil(t, err, "should add dirty column")
+	assert.Equal(t, 0, dirty, "transactional failure should not leave dirty version")
+
+	//version left dirty by a half applied mysql migration
+	_, err = db.Exec("UPDATE schema_migrations SET dirty = TRUE WHERE version = 1")
+	require.Nil(t, err)
+
+	_, err = m.Up(context.Background())
+	assert.ErrorContains(t, err, "migration 1_create_users is dirty", "should refuse to migrate")
+	_, err = m.Rollback(context.Background(), 1)
+	assert.ErrorContains(t, err, "migration 1_create_users is dirty", "should refuse to rollback")
+	_, err = NewMigrator(db, Sqlite, fsys, WithDryRun()).Up(context.Background())
+	assert.NotNil(t, err, "dry run should report dirty version")
+
+	//resolved by hand
+	_, err = db.Exec("UPDATE schema_migrations SET dirty = FALSE WHERE version = 1")
+	require.Nil(t, err)
+	reverted, err := m.Rollback(context.Background(), 1)
+	assert.Nil(t, err, "should nil")
+	assert.Len(t, reverted, 1, "should continue once resolved")
+}
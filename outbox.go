@@ -0,0 +1,411 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxMessage is row of outbox table, create it with MigrateOutbox or own migration
+type OutboxMessage struct {
+	ID            int64      `gorm:"primaryKey"`
+	Queue         string     `gorm:"size:255;not null"`
+	Payload       []byte     `gorm:"not null"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"size:1024"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_pending,priority:2"`
+	SentAt        *time.Time `gorm:"index:idx_outbox_pending,priority:1"`
+	DeadAt        *time.Time `gorm:"index"` //set when message run out of attempts
+	CreatedAt     time.Time  `gorm:"not null"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
+
+// MigrateOutbox create or update the outbox table
+func MigrateOutbox(db *gorm.DB) error {
+	if err := db.AutoMigrate(&OutboxMessage{}); err != nil {
+		return fmt.Errorf("fail migrate outbox : %w", err)
+	}
+	return nil
+}
+
+/*
+EnqueueOutbox write message for queue in the transaction carried by ctx, so it is
+published only when the transaction commit. Without transaction it is written directly.
+example :
+
+	err := tools.WithTx(ctx, db, nil, func(ctx context.Context, tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		return tools.EnqueueOutbox(ctx, db, "order.created", payload)
+	})
+*/
+func EnqueueOutbox(ctx context.Context, db *gorm.DB, queue string, payload []byte) error {
+	now := time.Now().UTC()
+	msg := OutboxMessage{Queue: queue, Payload: payload, NextAttemptAt: now, CreatedAt: now}
+	if err := DBFromContext(ctx, db).Create(&msg).Error; err != nil {
+		return fmt.Errorf("fail enqueue outbox message : %w", err)
+	}
+	return nil
+}
+
+// EnqueueOutboxJSON marshal v and enqueue it, see EnqueueOutbox
+func EnqueueOutboxJSON(ctx context.Context, db *gorm.DB, queue string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("fail marshal outbox message : %w", err)
+	}
+	return EnqueueOutbox(ctx, db, queue, payload)
+}
+
+// OutboxRelay publish pending outbox messages to RabbitMQ. Message is marked sent after
+// Publish succeed, so crash in between publish it again, consumers must be idempotent.
+// Message failing max attempts is moved to dead letter, see DeadLetters.
+type OutboxRelay struct {
+	db  *gorm.DB
+	mq  RabbitMQ
+	log *zap.Logger
+	now func() time.Time
+
+	batchSize   int
+	interval    time.Duration
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	retention   time.Duration
+	lease       time.Duration
+
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	started bool
+}
+
+type OutboxOption func(*OutboxRelay)
+
+// WithOutboxBatchSize limit messages claimed per round, default 100
+func WithOutboxBatchSize(n int) OutboxOption {
+	return func(r *OutboxRelay) {
+		r.batchSize = n
+	}
+}
+
+// WithOutboxInterval wait d between rounds when outbox is drained, default 1 second
+func WithOutboxInterval(d time.Duration) OutboxOption {
+	return func(r *OutboxRelay) {
+		r.interval = d
+	}
+}
+
+// WithOutboxMaxAttempts move message to dead letter after n failed publish, default 10
+func WithOutboxMaxAttempts(n int) OutboxOption {
+	return func(r *OutboxRelay) {
+		r.maxAttempts = n
+	}
+}
+
+// WithOutboxBackoff delay retry of failed message, doubled on every attempt, default 1s to 5m
+func WithOutboxBackoff(min, max time.Duration) OutboxOption {
+	return func(r *OutboxRelay) {
+		r.minBackoff = min
+		r.maxBackoff = max
+	}
+}
+
+// WithOutboxRetention delete sent messages older than d, default 7 days, negative keep them
+func WithOutboxRetention(d time.Duration) OutboxOption {
+	return func(r *OutboxRelay) {
+		r.retention = d
+	}
+}
+
+// WithOutboxLease hide claimed messages from other relays for d, default 1 minute.
+// Message of relay crashing before publish is retried once lease expire,
+// so d must be longer than publishing one batch.
+func WithOutboxLease(d time.Duration) OutboxOption {
+	return func(r *OutboxRelay) {
+		r.lease = d
+	}
+}
+
+func WithOutboxLogger(l *zap.Logger) OutboxOption {
+	return func(r *OutboxRelay) {
+		r.log = l
+	}
+}
+
+func NewOutboxRelay(db *gorm.DB, mq RabbitMQ, opts ...OutboxOption) *OutboxRelay {
+	r := &OutboxRelay{
+		db:          db,
+		mq:          mq,
+		log:         zap.NewNop(),
+		now:         func() time.Time { return time.Now().UTC() },
+		batchSize:   100,
+		interval:    time.Second,
+		maxAttempts: 10,
+		minBackoff:  time.Second,
+		maxBackoff:  5 * time.Minute,
+		retention:   7 * 24 * time.Hour,
+		lease:       time.Minute,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Start relay in background until Stop is called, calling it again does nothing
+func (r *OutboxRelay) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started {
+		return
+	}
+	r.started = true
+	go r.run()
+}
+
+// Stop wait for running round to finish
+func (r *OutboxRelay) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+
+	r.mu.Lock()
+	started := r.started
+	r.mu.Unlock()
+
+	if started {
+		<-r.done
+	}
+}
+
+func (r *OutboxRelay) run() {
+	defer close(r.done)
+
+	//round is not cancelled by Stop, aborting it would leave published messages unmarked
+	ctx := context.Background()
+
+	lastCleanup := time.Time{}
+	for {
+		sent, err := r.RelayOnce(ctx)
+		if err != nil {
+			r.log.Error("outbox relay", zap.Error(err))
+		}
+
+		//sent rows pile up slowly, hourly cleanup is enough
+		if r.retention > 0 && time.Since(lastCleanup) > time.Hour {
+			if _, err := r.Cleanup(ctx); err != nil {
+				r.log.Error("outbox cleanup", zap.Error(err))
+			}
+			lastCleanup = time.Now()
+		}
+
+		//full batch means more is waiting
+		wait := r.interval
+		if err == nil && sent >= r.batchSize {
+			wait = 0
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayOnce claim one batch of due messages and publish them, return number of messages sent.
+// Rows are claimed with SKIP LOCKED and leased by moving next_attempt_at forward, then
+// published outside of the claiming transaction, so several relays share the outbox
+// without publishing twice and no row lock is held during broker calls.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	batch, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var errs []error
+	for _, msg := range batch {
+		ok, err := r.publish(ctx, msg)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if ok {
+			sent++
+		}
+	}
+
+	return sent, errors.Join(errs...)
+}
+
+// claim lock due messages and lease them in one short transaction
+func (r *OutboxRelay) claim(ctx context.Context) ([]OutboxMessage, error) {
+	var batch []OutboxMessage
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := r.now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND dead_at IS NULL AND next_attempt_at <= ?", now).
+			Order("id").
+			Limit(r.batchSize).
+			Find(&batch).Error
+		if err != nil {
+			return fmt.Errorf("fail claim outbox messages : %w", err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, len(batch))
+		for i, msg := range batch {
+			ids[i] = msg.ID
+		}
+
+		err = tx.Model(&OutboxMessage{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(r.lease)).Error
+		if err != nil {
+			return fmt.Errorf("fail lease outbox messages : %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// publish send one claimed message and record the outcome, ok is true when it was sent
+func (r *OutboxRelay) publish(ctx context.Context, msg OutboxMessage) (bool, error) {
+	ok := false
+	attempts := msg.Attempts + 1
+	updates := map[string]interface{}{"attempts": attempts}
+
+	switch err := r.publishMessage(msg); {
+	case err == nil:
+		updates["sent_at"] = r.now()
+		ok = true
+	case attempts >= r.maxAttempts:
+		r.log.Error("outbox dead letter",
+			zap.Int64("id", msg.ID),
+			zap.String("queue", msg.Queue),
+			zap.Int("attempts", attempts),
+			zap.Error(err),
+		)
+		updates["last_error"] = truncate(err.Error(), 1024)
+		updates["dead_at"] = r.now()
+	default:
+		r.log.Warn("outbox publish",
+			zap.Int64("id", msg.ID),
+			zap.String("queue", msg.Queue),
+			zap.Int("attempts", attempts),
+			zap.Error(err),
+		)
+		updates["last_error"] = truncate(err.Error(), 1024)
+		updates["next_attempt_at"] = r.now().Add(r.backoff(msg.Attempts))
+	}
+
+	//sent message failing this update is published again after the lease
+	if err := r.db.WithContext(ctx).Model(&OutboxMessage{}).Where("id = ?", msg.ID).Updates(updates).Error; err != nil {
+		return ok, fmt.Errorf("fail update outbox message %d : %w", msg.ID, err)
+	}
+	return ok, nil
+}
+
+// publishMessage skip broker for message claimed after max attempts was lowered
+func (r *OutboxRelay) publishMessage(msg OutboxMessage) error {
+	if msg.Attempts >= r.maxAttempts {
+		return fmt.Errorf("max attempts reached : %s", msg.LastError)
+	}
+	return r.mq.Publish(msg.Queue, msg.Payload)
+}
+
+// DeadLetters list messages that ran out of attempts, oldest first
+func (r *OutboxRelay) DeadLetters(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	var msgs []OutboxMessage
+	err := r.db.WithContext(ctx).
+		Where("dead_at IS NOT NULL").
+		Order("id").
+		Limit(limit).
+		Find(&msgs).Error
+	if err != nil {
+		return nil, fmt.Errorf("fail list outbox dead letters : %w", err)
+	}
+	return msgs, nil
+}
+
+// RequeueDeadLetter reset attempts of dead message so it is published again
+func (r *OutboxRelay) RequeueDeadLetter(ctx context.Context, id int64) error {
+	res := r.db.WithContext(ctx).Model(&OutboxMessage{}).
+		Where("id = ? AND dead_at IS NOT NULL", id).
+		Updates(map[string]interface{}{"dead_at": nil, "attempts": 0, "next_attempt_at": r.now()})
+	if res.Error != nil {
+		return fmt.Errorf("fail requeue outbox message %d : %w", id, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("fail requeue outbox message %d : %w", id, gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// DeleteDeadLetters delete dead messages older than d, return number of rows deleted
+func (r *OutboxRelay) DeleteDeadLetters(ctx context.Context, d time.Duration) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("dead_at IS NOT NULL AND dead_at < ?", r.now().Add(-d)).
+		Delete(&OutboxMessage{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("fail delete outbox dead letters : %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// backoff of retry after attempts failed publish before this one
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	d := r.minBackoff
+	for i := 0; i < attempts && d < r.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	return d
+}
+
+// Cleanup delete sent messages older than retention, return number of rows deleted
+func (r *OutboxRelay) Cleanup(ctx context.Context) (int64, error) {
+	if r.retention <= 0 {
+		return 0, nil
+	}
+
+	res := r.db.WithContext(ctx).
+		Where("sent_at IS NOT NULL AND sent_at < ?", r.now().Add(-r.retention)).
+		Delete(&OutboxMessage{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("fail cleanup outbox : %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
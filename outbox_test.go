@@ -0,0 +1,198 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTestOutbox(t *testing.T) *gorm.DB {
+	db, err := OpenGormDB(DBConfiguration{DbType: Sqlite})
+	require.Nil(t, err)
+	require.Nil(t, MigrateOutbox(db))
+
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	return db
+}
+
+func outboxMessages(t *testing.T, db *gorm.DB) []OutboxMessage {
+	var msgs []OutboxMessage
+	require.Nil(t, db.Order("id").Find(&msgs).Error)
+	return msgs
+}
+
+func TestEnqueueOutbox(t *testing.T) {
+	db := newTestOutbox(t)
+	ctx := context.Background()
+
+	err := WithTx(ctx, db, nil, func(ctx context.Context, tx *gorm.DB) error {
+		return EnqueueOutboxJSON(ctx, db, "orders", map[string]int{"id": 1})
+	})
+	assert.Nil(t, err, "should nil")
+
+	err = WithTx(ctx, db, nil, func(ctx context.Context, tx *gorm.DB) error {
+		if err := EnqueueOutbox(ctx, db, "orders", []byte("lost")); err != nil {
+			return err
+		}
+		return errors.New("boom")
+	})
+	assert.NotNil(t, err, "should error")
+
+	msgs := outboxMessages(t, db)
+	require.Len(t, msgs, 1, "should keep committed message only")
+	assert.Equal(t, "orders", msgs[0].Queue, "should store queue")
+	assert.Equal(t, `{"id":1}`, string(msgs[0].Payload), "should store payload")
+	assert.Nil(t, msgs[0].SentAt, "should be pending")
+}
+
+func TestOutboxRelayOnce(t *testing.T) {
+	db := newTestOutbox(t)
+	ctx := context.Background()
+
+	require.Nil(t, EnqueueOutbox(ctx, db, "orders", []byte("1")))
+	require.Nil(t, EnqueueOutbox(ctx, db, "mails", []byte("2")))
+
+	now := time.Now().UTC()
+
+	mq := &MockRabbitMQ{}
+	//in memory sqlite has one connection, so reading here prove claim tx is committed
+	mq.On("Publish", "orders", []byte("1")).Run(func(args mock.Arguments) {
+		var msg OutboxMessage
+		require.Nil(t, db.First(&msg, "queue = ?", "orders").Error)
+		assert.True(t, msg.NextAttemptAt.After(now), "should lease claimed message")
+	}).Return(nil)
+	mq.On("Publish", "mails", []byte("2")).Return(errors.New("channel closed")).Once()
+
+	r := NewOutboxRelay(db, mq, WithOutboxBackoff(time.Minute, time.Hour), WithOutboxMaxAttempts(2))
+	r.now = func() time.Time { return now }
+
+	sent, err := r.RelayOnce(ctx)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, 1, sent, "should send one")
+
+	msgs := outboxMessages(t, db)
+	assert.NotNil(t, msgs[0].SentAt, "should mark sent")
+	assert.Nil(t, msgs[1].SentAt, "should stay pending")
+	assert.Equal(t, 1, msgs[1].Attempts, "should count attempt")
+	assert.Equal(t, "channel closed", msgs[1].LastError, "should keep error")
+	assert.WithinDuration(t, now.Add(time.Minute), msgs[1].NextAttemptAt, time.Second, "should back off")
+
+	//not due yet
+	sent, err = r.RelayOnce(ctx)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, 0, sent, "should wait for backoff")
+
+	mq.On("Publish", "mails", []byte("2")).Return(errors.New("channel closed")).Once()
+	now = now.Add(2 * time.Minute)
+	sent, _ = r.RelayOnce(ctx)
+	assert.Equal(t, 0, sent, "should fail again")
+
+	//max attempts reached, message is dead
+	now = now.Add(time.Hour)
+	sent, _ = r.RelayOnce(ctx)
+	assert.Equal(t, 0, sent, "should stop retrying")
+	assert.Equal(t, 2, outboxMessages(t, db)[1].Attempts, "should not publish again")
+	mq.AssertNumberOfCalls(t, "Publish", 3)
+
+	dead, err := r.DeadLetters(ctx, 10)
+	assert.Nil(t, err, "should nil")
+	require.Len(t, dead, 1, "should list dead message")
+	assert.Equal(t, "mails", dead[0].Queue, "should be failed message")
+	assert.NotNil(t, dead[0].DeadAt, "should mark dead")
+
+	mq.On("Publish", "mails", []byte("2")).Return(nil).Once()
+	assert.Nil(t, r.RequeueDeadLetter(ctx, dead[0].ID), "should nil")
+	assert.NotNil(t, r.RequeueDeadLetter(ctx, dead[0].ID), "should error on live message")
+	sent, err = r.RelayOnce(ctx)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, 1, sent, "should publish requeued message")
+}
+
+func TestOutboxDeadLetterCleanup(t *testing.T) {
+	db := newTestOutbox(t)
+	ctx := context.Background()
+
+	require.Nil(t, EnqueueOutbox(ctx, db, "orders", []byte("1")))
+
+	mq := &MockRabbitMQ{}
+	mq.On("Publish", "orders", []byte("1")).Return(errors.New("down"))
+
+	now := time.Now().UTC()
+	r := NewOutboxRelay(db, mq, WithOutboxMaxAttempts(1))
+	r.now = func() time.Time { return now }
+
+	_, _ = r.RelayOnce(ctx)
+
+	deleted, err := r.DeleteDeadLetters(ctx, time.Hour)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(0), deleted, "should keep recent dead letter")
+
+	now = now.Add(2 * time.Hour)
+	deleted, err = r.DeleteDeadLetters(ctx, time.Hour)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(1), deleted, "should delete old dead letter")
+}
+
+func TestOutboxCleanup(t *testing.T) {
+	db := newTestOutbox(t)
+	ctx := context.Background()
+
+	require.Nil(t, EnqueueOutbox(ctx, db, "orders", []byte("1")))
+	require.Nil(t, EnqueueOutbox(ctx, db, "orders", []byte("2")))
+
+	mq := &MockRabbitMQ{}
+	mq.On("Publish", "orders", []byte("1")).Return(nil)
+	mq.On("Publish", "orders", []byte("2")).Return(errors.New("down"))
+
+	now := time.Now().UTC()
+	r := NewOutboxRelay(db, mq, WithOutboxRetention(24*time.Hour))
+	r.now = func() time.Time { return now }
+
+	_, err := r.RelayOnce(ctx)
+	assert.Nil(t, err, "should nil")
+
+	deleted, err := r.Cleanup(ctx)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(0), deleted, "should keep recent messages")
+
+	now = now.Add(25 * time.Hour)
+	deleted, err = r.Cleanup(ctx)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(1), deleted, "should delete old sent message")
+	assert.Len(t, outboxMessages(t, db), 1, "should keep unsent message")
+}
+
+func TestOutboxRelayStart(t *testing.T) {
+	db := newTestOutbox(t)
+	ctx := context.Background()
+
+	published := make(chan []byte, 10)
+	mq := &MockRabbitMQ{}
+	mq.On("Publish", "orders", mock.Anything).Run(func(args mock.Arguments) {
+		published <- args.Get(1).([]byte)
+	}).Return(nil)
+
+	r := NewOutboxRelay(db, mq, WithOutboxInterval(10*time.Millisecond))
+	r.Start()
+	r.Start()
+	defer r.Stop()
+	defer r.Stop()
+
+	require.Nil(t, EnqueueOutbox(ctx, db, "orders", []byte("1")))
+
+	select {
+	case msg := <-published:
+		assert.Equal(t, []byte("1"), msg, "should publish")
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not relayed")
+	}
+}
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/julianto0911/redismq"
@@ -14,10 +15,16 @@ type StatsQueue struct {
 	DataConsumer *redismq.Consumer
 }
 
+// NewStatsQueue connect queues on redis db 9, only single node without username or TLS
+// is supported because redismq dial by itself and can't take a client
 func NewStatsQueue(rds RedisConfiguration) (*StatsQueue, error) {
-	obj := StatsQueue{}
+	host, port, err := statsQueueAddr(rds)
+	if err != nil {
+		return nil, err
+	}
+	rds.Host, rds.Port = host, port
 
-	var err error
+	obj := StatsQueue{}
 
 	//add first statistics queue components
 	obj.BQueue, err = redismq.SelectQueue(rds.Host, rds.Port, rds.Password, 9, rds.Prefix+"_b_queue")
@@ -55,3 +62,27 @@ func (s *StatsQueue) Close() {
 	defer s.BConsumer.Quit()
 	defer s.DataConsumer.Quit()
 }
+
+// statsQueueAddr resolve address like NewUniversalRedisClient and reject settings
+// redismq would silently ignore
+func statsQueueAddr(rds RedisConfiguration) (string, string, error) {
+	if mode := rds.RedisMode(); mode != RedisModeSingle {
+		return "", "", fmt.Errorf("stats queue only support single node redis, got %s", mode)
+	}
+	if rds.Username != "" || rds.TLS {
+		return "", "", fmt.Errorf("stats queue doesn't support redis username or tls")
+	}
+
+	if len(rds.Addrs) == 0 {
+		if rds.Host == "" {
+			return "", "", fmt.Errorf("redis address is required")
+		}
+		return rds.Host, rds.Port, nil
+	}
+
+	host, port, err := net.SplitHostPort(rds.Addrs[0])
+	if err != nil {
+		return "", "", fmt.Errorf("fail parse redis address %s : %w", rds.Addrs[0], err)
+	}
+	return host, port, nil
+}
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
@@ -34,6 +35,11 @@ func (m *MockRabbitMQ) Close() {
 	m.Called()
 }
 
+func (m *MockRabbitMQ) Ping() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 func rabbitMQString(user, password, host, port string) string {
 	return fmt.Sprintf("amqp://%s:%s@%s:%s", user, password, host, port)
 }
@@ -137,6 +143,17 @@ func (r *rabbitMQ) Consume(queueName string, handler func([]byte) error) error {
 	return nil
 }
 
+// Ping return error when connection or channel was closed
+func (r *rabbitMQ) Ping() error {
+	if r.conn == nil || r.conn.IsClosed() {
+		return errors.New("rabbitmq connection is closed")
+	}
+	if r.channel == nil || r.channel.IsClosed() {
+		return errors.New("rabbitmq channel is closed")
+	}
+	return nil
+}
+
 // Close closes the RabbitMQ connection
 func (r *rabbitMQ) Close() {
 	if r.channel != nil {
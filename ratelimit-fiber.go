@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"crypto/rsa"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type FiberKeyFunc func(c *fiber.Ctx) string
+
+// FiberRateLimit reject request with 429 when limiter deny it.
+// When redis is unavailable the request is let through.
+func FiberRateLimit(l RateLimiter, keyFn FiberKeyFunc) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		res, err := l.Allow(c.UserContext(), keyFn(c))
+		if err != nil {
+			return c.Next()
+		}
+
+		for k, v := range rateLimitHeaders(res) {
+			c.Set(k, v)
+		}
+
+		if !res.Allowed {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"message": "too many requests"})
+		}
+
+		return c.Next()
+	}
+}
+
+func FiberKeyByIP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// FiberKeyByJWTUser key by user id from bearer token, fallback to client ip
+func FiberKeyByJWTUser(publicKey *rsa.PublicKey) FiberKeyFunc {
+	return func(c *fiber.Ctx) string {
+		if key := rateLimitUserID(publicKey, c.Get(fiber.HeaderAuthorization)); key != "" {
+			return key
+		}
+		return FiberKeyByIP(c)
+	}
+}
@@ -0,0 +1,47 @@
+package tools
+
+import (
+	"crypto/rsa"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GinKeyFunc func(c *gin.Context) string
+
+// GinRateLimit reject request with 429 when limiter deny it.
+// When redis is unavailable the request is let through.
+func GinRateLimit(l RateLimiter, keyFn GinKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := l.Allow(c.Request.Context(), keyFn(c))
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		for k, v := range rateLimitHeaders(res) {
+			c.Header(k, v)
+		}
+
+		if !res.Allowed {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "too many requests"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func GinKeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// GinKeyByJWTUser key by user id from bearer token, fallback to client ip
+func GinKeyByJWTUser(publicKey *rsa.PublicKey) GinKeyFunc {
+	return func(c *gin.Context) string {
+		if key := rateLimitUserID(publicKey, c.GetHeader("Authorization")); key != "" {
+			return key
+		}
+		return GinKeyByIP(c)
+	}
+}
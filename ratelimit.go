@@ -0,0 +1,212 @@
+package tools
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sliding window log, every allowed request is a member of sorted set scored by time
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local count = redis.call("ZCARD", key)
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", key, now, ARGV[4])
+	redis.call("PEXPIRE", key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+local reset = window
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+return {allowed, limit - count, reset, retry}
+`)
+
+// token bucket refilled continuously, state is kept in a hash
+var tokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local data = redis.call("HMGET", key, "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call("HSET", key, "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", key, math.ceil(burst / rate))
+return {allowed, math.floor(tokens), math.ceil((burst - tokens) / rate), retry}
+`)
+
+// RateLimitResult describe limiter decision for single request
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration //time until the limit is fully restored
+	RetryAfter time.Duration //zero when allowed
+}
+
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// scripts work in whole milliseconds
+const minRateLimitPeriod = time.Millisecond
+
+// NewSlidingWindowLimiter allow limit requests within any window of given duration
+func NewSlidingWindowLimiter(rdb redis.UniversalClient, prefix string, limit int, window time.Duration) (RateLimiter, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %d", limit)
+	}
+	if window < minRateLimitPeriod {
+		return nil, fmt.Errorf("rate limit window must be at least %s, got %s", minRateLimitPeriod, window)
+	}
+
+	return &slidingWindowLimiter{
+		rdb:    rdb,
+		prefix: prefix,
+		limit:  limit,
+		window: window,
+	}, nil
+}
+
+type slidingWindowLimiter struct {
+	rdb    redis.UniversalClient
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+func (l *slidingWindowLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	now := time.Now().UnixMilli()
+	member := strconv.FormatInt(now, 10) + "-" + strconv.FormatInt(rand.Int63(), 36)
+
+	res, err := slidingWindowScript.Run(ctx, l.rdb, []string{rateLimitKey(l.prefix, key)},
+		now, l.window.Milliseconds(), l.limit, member).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("fail run rate limiter : %w", err)
+	}
+
+	return newRateLimitResult(l.limit, res), nil
+}
+
+// NewTokenBucketLimiter refill rate tokens every per duration, up to burst tokens
+func NewTokenBucketLimiter(rdb redis.UniversalClient, prefix string, rate int, per time.Duration, burst int) (RateLimiter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("token bucket rate must be positive, got %d", rate)
+	}
+	if per < minRateLimitPeriod {
+		return nil, fmt.Errorf("token bucket period must be at least %s, got %s", minRateLimitPeriod, per)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("token bucket burst must be positive, got %d", burst)
+	}
+
+	return &tokenBucketLimiter{
+		rdb:    rdb,
+		prefix: prefix,
+		rate:   float64(rate) / float64(per.Milliseconds()),
+		burst:  burst,
+	}, nil
+}
+
+type tokenBucketLimiter struct {
+	rdb    redis.UniversalClient
+	prefix string
+	rate   float64 //tokens per millisecond
+	burst  int
+}
+
+func (l *tokenBucketLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	res, err := tokenBucketScript.Run(ctx, l.rdb, []string{rateLimitKey(l.prefix, key)},
+		time.Now().UnixMilli(), strconv.FormatFloat(l.rate, 'g', -1, 64), l.burst).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("fail run rate limiter : %w", err)
+	}
+
+	return newRateLimitResult(l.burst, res), nil
+}
+
+func rateLimitKey(prefix, key string) string {
+	return prefix + "_ratelimit_" + key
+}
+
+// res is {allowed, remaining, reset ms, retry ms}
+func newRateLimitResult(limit int, res []int64) RateLimitResult {
+	return RateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      limit,
+		Remaining:  int(res[1]),
+		ResetAfter: time.Duration(res[2]) * time.Millisecond,
+		RetryAfter: time.Duration(res[3]) * time.Millisecond,
+	}
+}
+
+// RateLimit-* and Retry-After header values, durations are rounded up to seconds
+func rateLimitHeaders(res RateLimitResult) map[string]string {
+	h := map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(res.Limit),
+		"RateLimit-Remaining": strconv.Itoa(res.Remaining),
+		"RateLimit-Reset":     strconv.FormatInt(ceilSeconds(res.ResetAfter), 10),
+	}
+
+	if !res.Allowed {
+		h["Retry-After"] = strconv.FormatInt(ceilSeconds(res.RetryAfter), 10)
+	}
+
+	return h
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// user id from "Bearer <token>" header, empty when token is missing or invalid
+func rateLimitUserID(publicKey *rsa.PublicKey, authorization string) string {
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found || token == "" {
+		return ""
+	}
+
+	claims, err := ParseToken(publicKey, token)
+	if err != nil {
+		return ""
+	}
+
+	return "user:" + Int64ToString(claims.UserID)
+}
@@ -0,0 +1,149 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	l, err := NewSlidingWindowLimiter(rds, "test", 3, time.Minute)
+	assert.Nil(t, err, "should nil")
+
+	for i := 0; i < 3; i++ {
+		res, err := l.Allow(ctx, "client")
+		assert.Nil(t, err, "should nil")
+		assert.True(t, res.Allowed, "should be allowed")
+		assert.Equal(t, 2-i, res.Remaining, "remaining should decrease")
+	}
+
+	res, err := l.Allow(ctx, "client")
+	assert.Nil(t, err, "should nil")
+	assert.False(t, res.Allowed, "should be denied")
+	assert.Equal(t, 0, res.Remaining, "should have nothing remaining")
+	assert.Greater(t, res.RetryAfter, 59*time.Second, "should retry after window")
+
+	//other key has its own window
+	res, err = l.Allow(ctx, "other")
+	assert.Nil(t, err, "should nil")
+	assert.True(t, res.Allowed, "should be allowed")
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	l, err := NewTokenBucketLimiter(rds, "test", 1, time.Minute, 2)
+	assert.Nil(t, err, "should nil")
+
+	for i := 0; i < 2; i++ {
+		res, err := l.Allow(ctx, "client")
+		assert.Nil(t, err, "should nil")
+		assert.True(t, res.Allowed, "burst should be allowed")
+	}
+
+	res, err := l.Allow(ctx, "client")
+	assert.Nil(t, err, "should nil")
+	assert.False(t, res.Allowed, "should be denied")
+	assert.Greater(t, res.RetryAfter, 50*time.Second, "should wait for next token")
+	assert.LessOrEqual(t, res.RetryAfter, time.Minute, "should wait for next token")
+}
+
+func TestNewRateLimiterValidation(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	_, err = NewSlidingWindowLimiter(rds, "test", 0, time.Minute)
+	assert.NotNil(t, err, "should reject zero limit")
+	_, err = NewSlidingWindowLimiter(rds, "test", 1, time.Microsecond)
+	assert.NotNil(t, err, "should reject sub millisecond window")
+
+	_, err = NewTokenBucketLimiter(rds, "test", 0, time.Minute, 1)
+	assert.NotNil(t, err, "should reject zero rate")
+	_, err = NewTokenBucketLimiter(rds, "test", 1, 0, 1)
+	assert.NotNil(t, err, "should reject zero period")
+	_, err = NewTokenBucketLimiter(rds, "test", 1, time.Minute, -1)
+	assert.NotNil(t, err, "should reject negative burst")
+}
+
+func TestGinRateLimit(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	l, err := NewSlidingWindowLimiter(rds, "gin", 1, time.Minute)
+	assert.Nil(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinRateLimit(l, GinKeyByIP))
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestFiberRateLimit(t *testing.T) {
+	rds, err := MockRedis()
+	assert.Nil(t, err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	token, err := CreateToken(string(privatePEM), "john", "admin", 42, 1, time.Hour)
+	assert.Nil(t, err)
+
+	l, err := NewSlidingWindowLimiter(rds, "fiber", 1, time.Minute)
+	assert.Nil(t, err)
+
+	app := fiber.New()
+	app.Use(FiberRateLimit(l, FiberKeyByJWTUser(&key.PublicKey)))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	request := func(token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		assert.Nil(t, err)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, request(token).StatusCode)
+
+	resp := request(token)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	//anonymous request is keyed by ip, not by the limited user
+	assert.Equal(t, http.StatusOK, request("").StatusCode)
+
+	//user key is used
+	n, err := rds.Exists(context.Background(), rateLimitKey("fiber", "user:42")).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n, "should key by jwt user id")
+}
@@ -0,0 +1,301 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	ReplicaPolicyRoundRobin = "round_robin"
+	ReplicaPolicyRandom     = "random"
+)
+
+const replicaPluginName = "tools:replicas"
+
+// ReplicaConfig is read replica reachable with credentials of the primary
+type ReplicaConfig struct {
+	Host string
+	Port string
+}
+
+type usePrimaryCtxKey struct{}
+
+// UsePrimary force every query run with ctx to the primary, useful to read own writes
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, usePrimaryCtxKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	force, _ := ctx.Value(usePrimaryCtxKey{}).(bool)
+	return force
+}
+
+// UseReplicas connect cfg.Replicas and route reads of db to them, writes and
+// transactions stay on the primary. Replicas failing ping or lagging more than
+// cfg.ReplicaMaxLag are skipped until healthy again, reads go to the primary
+// when no replica is healthy. Call CloseReplicas to release them.
+func UseReplicas(db *gorm.DB, cfg DBConfiguration) error {
+	primary, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	set := &replicaSet{
+		dbType:   cfg.DbType,
+		primary:  primary,
+		policy:   cfg.ReplicaPolicy,
+		maxLag:   cfg.ReplicaMaxLag,
+		interval: cfg.ReplicaCheckInterval,
+		stop:     make(chan struct{}),
+	}
+	if set.interval <= 0 {
+		set.interval = 10 * time.Second
+	}
+
+	dialectors := make([]gorm.Dialector, 0, len(cfg.Replicas))
+	for _, r := range cfg.Replicas {
+		replicaCfg := cfg
+		replicaCfg.Host = r.Host
+		replicaCfg.Port = r.Port
+		replicaCfg.Migrate = false
+		replicaCfg.Replicas = nil
+
+		conn, err := ConnectDB(replicaCfg)
+		if err != nil {
+			set.closeReplicas()
+			return fmt.Errorf("fail connect replica %s:%s : %w", r.Host, r.Port, err)
+		}
+
+		rep := &replica{addr: r.Host + ":" + r.Port, db: conn}
+		rep.healthy.Store(true)
+		set.replicas = append(set.replicas, rep)
+		dialectors = append(dialectors, gormDialector(cfg.DbType, conn))
+	}
+
+	//replica down at boot must not fail the primary, health check exclude it instead
+	db.Config.DisableAutomaticPing = true
+
+	if err := db.Use(dbresolver.Register(dbresolver.Config{Replicas: dialectors, Policy: set})); err != nil {
+		set.closeReplicas()
+		return fmt.Errorf("fail register replicas : %w", err)
+	}
+
+	if err := db.Use(set); err != nil {
+		set.closeReplicas()
+		return fmt.Errorf("fail register replicas : %w", err)
+	}
+
+	go set.run()
+
+	return nil
+}
+
+// CloseReplicas stop health check and close replica pools registered by UseReplicas
+func CloseReplicas(db *gorm.DB) error {
+	set, ok := db.Config.Plugins[replicaPluginName].(*replicaSet)
+	if !ok {
+		return nil
+	}
+
+	set.once.Do(func() {
+		close(set.stop)
+	})
+
+	return set.closeReplicas()
+}
+
+type replica struct {
+	addr    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicaSet is both the dbresolver policy and a gorm plugin keeping the replicas
+type replicaSet struct {
+	dbType   string
+	primary  *sql.DB
+	replicas []*replica
+	policy   string
+	maxLag   time.Duration
+	interval time.Duration
+	next     atomic.Uint64
+	stop     chan struct{}
+	once     sync.Once
+}
+
+func (s *replicaSet) Name() string {
+	return replicaPluginName
+}
+
+// Initialize route reads of UsePrimary context and reads resolved to unhealthy
+// replica back to the primary, dbresolver skip the policy when there is single replica
+func (s *replicaSet) Initialize(db *gorm.DB) error {
+	route := func(db *gorm.DB) {
+		if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); inTx {
+			return
+		}
+
+		pool := db.Statement.ConnPool
+		if prepared, ok := pool.(*gorm.PreparedStmtDB); ok {
+			pool = prepared.ConnPool
+		}
+
+		if usePrimary(db.Statement.Context) || !s.isHealthy(pool) {
+			dbresolver.Write.ModifyStatement(db.Statement)
+		}
+	}
+
+	//dbresolver run first of all callbacks
+	cb := db.Callback()
+	return errors.Join(
+		cb.Query().Before("gorm:query").Register("tools:replica_route", route),
+		cb.Row().Before("gorm:row").Register("tools:replica_route", route),
+		cb.Raw().Before("gorm:raw").Register("tools:replica_route", route),
+	)
+}
+
+// Resolve pick healthy replica by policy, or the primary when none is healthy
+func (s *replicaSet) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	healthy := make([]gorm.ConnPool, 0, len(pools))
+	for _, pool := range pools {
+		if s.isHealthy(pool) {
+			healthy = append(healthy, pool)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return s.primary
+	}
+
+	if s.policy == ReplicaPolicyRandom {
+		return healthy[rand.Intn(len(healthy))]
+	}
+
+	return healthy[s.next.Add(1)%uint64(len(healthy))]
+}
+
+func (s *replicaSet) isHealthy(pool gorm.ConnPool) bool {
+	for _, r := range s.replicas {
+		if gorm.ConnPool(r.db) == pool {
+			return r.healthy.Load()
+		}
+	}
+	return true
+}
+
+func (s *replicaSet) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.check()
+		}
+	}
+}
+
+func (s *replicaSet) check() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		err := r.db.PingContext(ctx)
+		if err == nil && s.maxLag > 0 {
+			var lag time.Duration
+			lag, err = replicationLag(ctx, s.dbType, r.db)
+			if err == nil && lag > s.maxLag {
+				err = fmt.Errorf("replica %s lag %s", r.addr, lag)
+			}
+		}
+		cancel()
+
+		r.healthy.Store(err == nil)
+	}
+}
+
+func (s *replicaSet) closeReplicas() error {
+	var errs []error
+	for _, r := range s.replicas {
+		if err := r.db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("replica %s: %w", r.addr, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("errors closing replicas: %v", errs)
+	}
+	return nil
+}
+
+// replicationLag measure how far replica is behind its primary. On postgres an
+// idle primary also look lagging, since the last replayed transaction get old.
+func replicationLag(ctx context.Context, dbType string, db *sql.DB) (time.Duration, error) {
+	if dbType == Postgresql {
+		var seconds float64
+		err := db.QueryRowContext(ctx, `SELECT CASE WHEN pg_is_in_recovery()
+			THEN COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) ELSE 0 END`).Scan(&seconds)
+		if err != nil {
+			return 0, fmt.Errorf("fail read replication lag : %w", err)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		//before mysql 8.0.22
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("fail read replication lag : %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if !rows.Next() {
+		//not a replica
+		return 0, rows.Err()
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+		seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type replicaTestUser struct {
+	ID   int64
+	Name string
+}
+
+func TestUseReplicas(t *testing.T) {
+	//pools are lazy, nothing listen on these ports
+	cfg := DBConfiguration{
+		DbType:         Postgresql,
+		Host:           "127.0.0.1",
+		Port:           "1",
+		Username:       "user",
+		ConnectTimeOut: 1,
+		Replicas: []ReplicaConfig{
+			{Host: "127.0.0.1", Port: "2"},
+			{Host: "127.0.0.1", Port: "3"},
+		},
+		ReplicaCheckInterval: time.Hour,
+	}
+
+	conn, err := ConnectDB(cfg)
+	assert.Nil(t, err, "should nil")
+	defer conn.Close()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{DisableAutomaticPing: true})
+	assert.Nil(t, err, "should nil")
+
+	err = UseReplicas(db, cfg)
+	assert.Nil(t, err, "should nil")
+
+	set := db.Config.Plugins[replicaPluginName].(*replicaSet)
+	assert.Len(t, set.replicas, 2, "should connect replicas")
+
+	dry := db.Session(&gorm.Session{DryRun: true})
+	pool := func(tx *gorm.DB) gorm.ConnPool {
+		return tx.Statement.ConnPool
+	}
+
+	//reads are balanced over replicas
+	first := pool(dry.Find(&[]replicaTestUser{}))
+	second := pool(dry.Find(&[]replicaTestUser{}))
+	assert.NotSame(t, gorm.ConnPool(conn), first, "should read from replica")
+	assert.NotSame(t, first, second, "should round robin")
+
+	//writes and forced reads go to primary
+	assert.Same(t, gorm.ConnPool(conn), pool(dry.Create(&replicaTestUser{Name: "a"})), "should write to primary")
+	assert.Same(t, gorm.ConnPool(conn), pool(dry.WithContext(UsePrimary(context.Background())).Find(&[]replicaTestUser{})), "should read from primary")
+
+	//ping fail, so every replica become unhealthy
+	set.check()
+	assert.Same(t, gorm.ConnPool(conn), pool(dry.Find(&[]replicaTestUser{})), "should fallback to primary")
+
+	assert.Nil(t, CloseReplicas(db), "should nil")
+	_, err = set.replicas[0].db.Conn(context.Background())
+	assert.Equal(t, "sql: database is closed", err.Error(), "should close replicas")
+}
+
+func TestReplicaSetResolve(t *testing.T) {
+	a, b, primary := &sql.DB{}, &sql.DB{}, &sql.DB{}
+	set := &replicaSet{primary: primary, replicas: []*replica{{db: a}, {db: b}}}
+	set.replicas[0].healthy.Store(true)
+
+	//single healthy replica always chosen
+	for i := 0; i < 3; i++ {
+		assert.Same(t, gorm.ConnPool(a), set.Resolve([]gorm.ConnPool{a, b}), "should skip unhealthy replica")
+	}
+
+	set.replicas[0].healthy.Store(false)
+	assert.Same(t, gorm.ConnPool(primary), set.Resolve([]gorm.ConnPool{a, b}), "should use primary")
+}
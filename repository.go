@@ -0,0 +1,543 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrInvalidListQuery       = errors.New("invalid list query")
+	ErrInvalidCursor          = errors.New("invalid cursor")
+	ErrSoftDeleteNotSupported = errors.New("model does not support soft delete")
+)
+
+// filter operators, written as field[op]=value in query string, plain field=value is eq
+const (
+	FilterEq   = "eq"
+	FilterNe   = "ne"
+	FilterGt   = "gt"
+	FilterGte  = "gte"
+	FilterLt   = "lt"
+	FilterLte  = "lte"
+	FilterLike = "like"
+	FilterIn   = "in" //comma separated values
+)
+
+type Filter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListQuery describe one page, Field of filters and sorts are names exposed by ListSpec
+type ListQuery struct {
+	Filters     []Filter
+	Sort        []SortField
+	Limit       int
+	Page        int    //offset pagination, start at 1
+	Cursor      string //keyset pagination, NextCursor of previous page
+	WithDeleted bool   //include soft deleted rows
+}
+
+// ListSpec whitelist what client may sort and filter on, keys are names used
+// in query string and values are database columns
+type ListSpec struct {
+	Sortable     map[string]string
+	Filterable   map[string]string
+	DefaultSort  []SortField
+	DefaultLimit int //default 20
+	MaxLimit     int //default 100
+}
+
+/*
+Parse read list query from query string, unknown filter keys are ignored.
+example :
+
+	?sort=-created_at,name&limit=20&page=2&status=active&age[gte]=18&id[in]=1,2,3
+*/
+func (s ListSpec) Parse(values url.Values) (ListQuery, error) {
+	q := ListQuery{Sort: s.DefaultSort, Cursor: values.Get("cursor")}
+
+	if sort := values.Get("sort"); sort != "" {
+		q.Sort = nil
+		for _, field := range strings.Split(sort, ",") {
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			if _, ok := s.Sortable[field]; !ok {
+				return q, fmt.Errorf("%w : cannot sort by %s", ErrInvalidListQuery, field)
+			}
+			q.Sort = append(q.Sort, SortField{Field: field, Desc: desc})
+		}
+	}
+
+	var err error
+	if q.Limit, err = parseQueryInt(values, "limit"); err != nil {
+		return q, err
+	}
+	if q.Page, err = parseQueryInt(values, "page"); err != nil {
+		return q, err
+	}
+
+	for key, vals := range values {
+		field, op := key, FilterEq
+		if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+			field, op = key[:i], key[i+1:len(key)-1]
+		}
+
+		if _, ok := s.Filterable[field]; !ok {
+			continue
+		}
+		if !validFilterOp(op) {
+			return q, fmt.Errorf("%w : unknown operator %s", ErrInvalidListQuery, op)
+		}
+
+		for _, v := range vals {
+			q.Filters = append(q.Filters, Filter{Field: field, Op: op, Value: v})
+		}
+	}
+
+	return q, nil
+}
+
+func parseQueryInt(values url.Values, key string) (int, error) {
+	raw := values.Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w : %s must be positive number", ErrInvalidListQuery, key)
+	}
+	return n, nil
+}
+
+func validFilterOp(op string) bool {
+	switch op {
+	case FilterEq, FilterNe, FilterGt, FilterGte, FilterLt, FilterLte, FilterLike, FilterIn:
+		return true
+	}
+	return false
+}
+
+type Page[T any] struct {
+	Items      []T
+	Total      int64
+	Limit      int
+	Page       int    //offset pagination only
+	NextCursor string //keyset pagination only, empty on last page
+	HasMore    bool
+}
+
+// Repository give CRUD and pagination of model T, every method join
+// transaction carried by ctx, see WithTx
+type Repository[T any] struct {
+	db     *gorm.DB
+	spec   ListSpec
+	schema *schema.Schema
+}
+
+func NewRepository[T any](db *gorm.DB, spec ListSpec) (*Repository[T], error) {
+	if spec.DefaultLimit <= 0 {
+		spec.DefaultLimit = 20
+	}
+	if spec.MaxLimit <= 0 {
+		spec.MaxLimit = 100
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, fmt.Errorf("fail parse model : %w", err)
+	}
+	if stmt.Schema.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("model %s has no primary key", stmt.Schema.Name)
+	}
+
+	return &Repository[T]{db: db, spec: spec, schema: stmt.Schema}, nil
+}
+
+func (r *Repository[T]) conn(ctx context.Context) *gorm.DB {
+	return DBFromContext(ctx, r.db)
+}
+
+func (r *Repository[T]) pk() string {
+	return r.schema.PrioritizedPrimaryField.DBName
+}
+
+func (r *Repository[T]) deletedAt() *schema.Field {
+	for _, field := range r.schema.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return field
+		}
+	}
+	return nil
+}
+
+// SoftDelete report whether model has gorm.DeletedAt field
+func (r *Repository[T]) SoftDelete() bool {
+	return r.deletedAt() != nil
+}
+
+func (r *Repository[T]) Create(ctx context.Context, item *T) error {
+	return r.conn(ctx).Create(item).Error
+}
+
+// FindByID return gorm.ErrRecordNotFound when missing or soft deleted
+func (r *Repository[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
+	item := new(T)
+	err := r.conn(ctx).Where(clause.Eq{Column: clause.Column{Name: r.pk()}, Value: id}).Take(item).Error
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Update save every field of item
+func (r *Repository[T]) Update(ctx context.Context, item *T) error {
+	return r.conn(ctx).Save(item).Error
+}
+
+// UpdateFields update only given columns of row id
+func (r *Repository[T]) UpdateFields(ctx context.Context, id interface{}, fields map[string]interface{}) error {
+	res := r.conn(ctx).Model(new(T)).Where(clause.Eq{Column: clause.Column{Name: r.pk()}, Value: id}).Updates(fields)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return res.Error
+}
+
+// Delete soft delete row when model support it, delete it otherwise
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
+	res := r.conn(ctx).Where(clause.Eq{Column: clause.Column{Name: r.pk()}, Value: id}).Delete(new(T))
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return res.Error
+}
+
+// HardDelete remove row even when model support soft delete
+func (r *Repository[T]) HardDelete(ctx context.Context, id interface{}) error {
+	res := r.conn(ctx).Unscoped().Where(clause.Eq{Column: clause.Column{Name: r.pk()}, Value: id}).Delete(new(T))
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return res.Error
+}
+
+// Restore undo soft delete of row id
+func (r *Repository[T]) Restore(ctx context.Context, id interface{}) error {
+	field := r.deletedAt()
+	if field == nil {
+		return ErrSoftDeleteNotSupported
+	}
+
+	res := r.conn(ctx).Unscoped().Model(new(T)).
+		Where(clause.Eq{Column: clause.Column{Name: r.pk()}, Value: id}).
+		Update(field.DBName, nil)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return res.Error
+}
+
+// ListOffset return page q.Page of q.Limit items with total count of matching rows
+func (r *Repository[T]) ListOffset(ctx context.Context, q ListQuery) (Page[T], error) {
+	limit := r.limit(q.Limit)
+	page := q.Page
+	if page < 1 {
+		page = 1
+	}
+
+	db, err := r.filtered(ctx, q)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	result := Page[T]{Limit: limit, Page: page}
+	if err := db.Session(&gorm.Session{}).Model(new(T)).Count(&result.Total).Error; err != nil {
+		return result, err
+	}
+
+	sorts, err := r.sortColumns(q.Sort)
+	if err != nil {
+		return result, err
+	}
+
+	for _, s := range sorts {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: s.Field}, Desc: s.Desc})
+	}
+
+	if err := db.Offset((page - 1) * limit).Limit(limit).Find(&result.Items).Error; err != nil {
+		return result, err
+	}
+
+	result.HasMore = int64(page*limit) < result.Total
+	return result, nil
+}
+
+// ListKeyset return q.Limit items after q.Cursor, faster than offset on deep pages.
+// Primary key is always added to the sort so every row has a stable position.
+func (r *Repository[T]) ListKeyset(ctx context.Context, q ListQuery) (Page[T], error) {
+	limit := r.limit(q.Limit)
+
+	db, err := r.filtered(ctx, q)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	result := Page[T]{Limit: limit}
+	if err := db.Session(&gorm.Session{}).Model(new(T)).Count(&result.Total).Error; err != nil {
+		return result, err
+	}
+
+	sorts, err := r.sortColumns(q.Sort)
+	if err != nil {
+		return result, err
+	}
+
+	if q.Cursor != "" {
+		values, err := decodeCursor(q.Cursor, sorts)
+		if err != nil {
+			return result, err
+		}
+		db = db.Where(keysetCondition(sorts, values))
+	}
+
+	for _, s := range sorts {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: s.Field}, Desc: s.Desc})
+	}
+
+	//one more row tell whether there is next page
+	if err := db.Limit(limit + 1).Find(&result.Items).Error; err != nil {
+		return result, err
+	}
+
+	if len(result.Items) > limit {
+		result.Items = result.Items[:limit]
+		result.HasMore = true
+
+		cursor, err := r.encodeCursor(ctx, sorts, result.Items[limit-1])
+		if err != nil {
+			return result, err
+		}
+		result.NextCursor = cursor
+	}
+
+	return result, nil
+}
+
+func (r *Repository[T]) limit(limit int) int {
+	if limit <= 0 {
+		return r.spec.DefaultLimit
+	}
+	if limit > r.spec.MaxLimit {
+		return r.spec.MaxLimit
+	}
+	return limit
+}
+
+func (r *Repository[T]) filtered(ctx context.Context, q ListQuery) (*gorm.DB, error) {
+	db := r.conn(ctx).Model(new(T))
+	if q.WithDeleted {
+		db = db.Unscoped()
+	}
+
+	for _, f := range q.Filters {
+		column, ok := r.spec.Filterable[f.Field]
+		if !ok {
+			return nil, fmt.Errorf("%w : cannot filter by %s", ErrInvalidListQuery, f.Field)
+		}
+
+		expr, err := filterExpression(clause.Column{Name: column}, f)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where(expr)
+	}
+
+	return db, nil
+}
+
+func filterExpression(column clause.Column, f Filter) (clause.Expression, error) {
+	switch f.Op {
+	case FilterEq, "":
+		return clause.Eq{Column: column, Value: f.Value}, nil
+	case FilterNe:
+		return clause.Neq{Column: column, Value: f.Value}, nil
+	case FilterGt:
+		return clause.Gt{Column: column, Value: f.Value}, nil
+	case FilterGte:
+		return clause.Gte{Column: column, Value: f.Value}, nil
+	case FilterLt:
+		return clause.Lt{Column: column, Value: f.Value}, nil
+	case FilterLte:
+		return clause.Lte{Column: column, Value: f.Value}, nil
+	case FilterLike:
+		//sqlite has no default escape character, so name it
+		return clause.Expr{SQL: "? LIKE ? ESCAPE ?", Vars: []interface{}{column, "%" + likeEscaper.Replace(f.Value) + "%", `\`}}, nil
+	case FilterIn:
+		values := []interface{}{}
+		for _, v := range strings.Split(f.Value, ",") {
+			values = append(values, v)
+		}
+		return clause.IN{Column: column, Values: values}, nil
+	}
+
+	return nil, fmt.Errorf("%w : unknown operator %s", ErrInvalidListQuery, f.Op)
+}
+
+// likeEscaper make wildcards in filter value match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// sortColumns map exposed names to columns, with primary key as tie breaker
+func (r *Repository[T]) sortColumns(sort []SortField) ([]SortField, error) {
+	if len(sort) == 0 {
+		sort = r.spec.DefaultSort
+	}
+
+	columns := make([]SortField, 0, len(sort)+1)
+	hasPK := false
+	for _, s := range sort {
+		column, ok := r.spec.Sortable[s.Field]
+		if !ok {
+			return nil, fmt.Errorf("%w : cannot sort by %s", ErrInvalidListQuery, s.Field)
+		}
+		hasPK = hasPK || column == r.pk()
+		columns = append(columns, SortField{Field: column, Desc: s.Desc})
+	}
+
+	if !hasPK {
+		columns = append(columns, SortField{Field: r.pk()})
+	}
+
+	return columns, nil
+}
+
+// keysetCondition build (a > x) OR (a = x AND b > y) ... following sort directions
+func keysetCondition(sorts []SortField, values []interface{}) clause.Expression {
+	var or []clause.Expression
+	for i, s := range sorts {
+		and := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: clause.Column{Name: sorts[j].Field}, Value: values[j]})
+		}
+
+		column := clause.Column{Name: s.Field}
+		if s.Desc {
+			and = append(and, clause.Lt{Column: column, Value: values[i]})
+		} else {
+			and = append(and, clause.Gt{Column: column, Value: values[i]})
+		}
+		or = append(or, clause.And(and...))
+	}
+
+	return clause.Or(or...)
+}
+
+type keysetCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	Times  []int         `json:"t,omitempty"` //index of time values, bound back as time.Time
+}
+
+func sortSignature(sorts []SortField) string {
+	parts := make([]string, 0, len(sorts))
+	for _, s := range sorts {
+		if s.Desc {
+			parts = append(parts, "-"+s.Field)
+		} else {
+			parts = append(parts, s.Field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *Repository[T]) encodeCursor(ctx context.Context, sorts []SortField, last T) (string, error) {
+	rv := reflect.ValueOf(&last).Elem()
+
+	cursor := keysetCursor{Sort: sortSignature(sorts)}
+	for _, s := range sorts {
+		field := r.schema.LookUpField(s.Field)
+		if field == nil {
+			return "", fmt.Errorf("%w : sort column %s is not a field of %s", ErrInvalidListQuery, s.Field, r.schema.Name)
+		}
+		value := field.ReflectValueOf(ctx, rv).Interface()
+		if _, ok := value.(time.Time); ok {
+			cursor.Times = append(cursor.Times, len(cursor.Values))
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("fail encode cursor : %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor decode integers as int64 so large ids do not lose precision
+func decodeCursor(raw string, sorts []SortField) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor keysetCursor
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	//cursor of other sort order would skip or repeat rows
+	if cursor.Sort != sortSignature(sorts) || len(cursor.Values) != len(sorts) {
+		return nil, ErrInvalidCursor
+	}
+
+	for i, v := range cursor.Values {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if integer, err := n.Int64(); err == nil {
+			cursor.Values[i] = integer
+		} else if f, err := n.Float64(); err == nil {
+			cursor.Values[i] = f
+		} else {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	//text would compare against the column as string on some databases
+	for _, i := range cursor.Times {
+		if i < 0 || i >= len(cursor.Values) {
+			return nil, ErrInvalidCursor
+		}
+		text, _ := cursor.Values[i].(string)
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		cursor.Values[i] = t
+	}
+
+	return cursor.Values, nil
+}
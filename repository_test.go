@@ -0,0 +1,242 @@
+package tools
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type repoTestUser struct {
+	ID        int64
+	Name      string
+	Age       int
+	CreatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+type repoTestTag struct {
+	ID   int64
+	Name string
+}
+
+// sqlRecorder keep every statement gorm build in dry run
+type sqlRecorder struct {
+	logger.Interface
+	sql []string
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.sql = append(r.sql, sql)
+}
+
+func newTestRepository[T any](t *testing.T) (*Repository[T], *sqlRecorder) {
+	conn, err := ConnectDB(DBConfiguration{DbType: Postgresql, Host: "127.0.0.1", Port: "1"})
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		Logger:                 rec,
+	})
+	require.Nil(t, err)
+
+	repo, err := NewRepository[T](db, ListSpec{
+		Sortable:   map[string]string{"name": "name", "created": "created_at", "id": "id"},
+		Filterable: map[string]string{"name": "name", "age": "age"},
+		MaxLimit:   50,
+	})
+	require.Nil(t, err)
+	return repo, rec
+}
+
+func TestListSpecParse(t *testing.T) {
+	spec := ListSpec{
+		Sortable:    map[string]string{"name": "name", "created": "created_at"},
+		Filterable:  map[string]string{"name": "name", "age": "age"},
+		DefaultSort: []SortField{{Field: "created", Desc: true}},
+	}
+
+	values, _ := url.ParseQuery("sort=-created,name&limit=10&page=2&name=bob&age[gte]=18&other=x")
+	q, err := spec.Parse(values)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, []SortField{{Field: "created", Desc: true}, {Field: "name"}}, q.Sort, "should parse sort")
+	assert.Equal(t, 10, q.Limit, "should parse limit")
+	assert.Equal(t, 2, q.Page, "should parse page")
+	assert.ElementsMatch(t, []Filter{
+		{Field: "name", Op: FilterEq, Value: "bob"},
+		{Field: "age", Op: FilterGte, Value: "18"},
+	}, q.Filters, "should parse whitelisted filters")
+
+	q, err = spec.Parse(url.Values{})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, spec.DefaultSort, q.Sort, "should use default sort")
+
+	_, err = spec.Parse(url.Values{"sort": {"password"}})
+	assert.ErrorIs(t, err, ErrInvalidListQuery, "should reject sort")
+
+	_, err = spec.Parse(url.Values{"age[drop]": {"1"}})
+	assert.ErrorIs(t, err, ErrInvalidListQuery, "should reject operator")
+
+	_, err = spec.Parse(url.Values{"limit": {"-1"}})
+	assert.ErrorIs(t, err, ErrInvalidListQuery, "should reject limit")
+}
+
+func TestRepositoryListOffset(t *testing.T) {
+	repo, rec := newTestRepository[repoTestUser](t)
+
+	page, err := repo.ListOffset(context.Background(), ListQuery{
+		Filters: []Filter{{Field: "age", Op: FilterGte, Value: "18"}},
+		Sort:    []SortField{{Field: "name", Desc: true}},
+		Limit:   500,
+		Page:    3,
+	})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, 50, page.Limit, "should cap limit")
+
+	require.Len(t, rec.sql, 2)
+	assert.Contains(t, rec.sql[0], "SELECT count(*)", "should count")
+	assert.Contains(t, rec.sql[0], `"age" >= '18'`, "should filter count")
+	assert.Contains(t, rec.sql[0], `"repo_test_users"."deleted_at" IS NULL`, "should skip deleted")
+	assert.Contains(t, rec.sql[1], `ORDER BY "name" DESC,"id"`, "should break tie with primary key")
+	assert.Contains(t, rec.sql[1], "LIMIT 50 OFFSET 100", "should offset")
+
+	_, err = repo.ListOffset(context.Background(), ListQuery{Filters: []Filter{{Field: "password", Value: "x"}}})
+	assert.ErrorIs(t, err, ErrInvalidListQuery, "should reject filter")
+
+	rec.sql = nil
+	_, err = repo.ListOffset(context.Background(), ListQuery{Filters: []Filter{{Field: "name", Op: FilterLike, Value: `50%_a\b`}}})
+	assert.Nil(t, err, "should nil")
+	assert.Contains(t, rec.sql[0], `"name" LIKE '%50\%\_a\\b%' ESCAPE '\'`, "should escape wildcards")
+
+	rec.sql = nil
+	_, err = repo.ListOffset(context.Background(), ListQuery{WithDeleted: true})
+	assert.Nil(t, err, "should nil")
+	assert.NotContains(t, strings.Join(rec.sql, ";"), "deleted_at", "should include deleted")
+}
+
+func TestRepositoryListKeyset(t *testing.T) {
+	repo, rec := newTestRepository[repoTestUser](t)
+	ctx := context.Background()
+	sorts, err := repo.sortColumns([]SortField{{Field: "created", Desc: true}})
+	require.Nil(t, err)
+
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	cursor, err := repo.encodeCursor(ctx, sorts, repoTestUser{ID: 9007199254740993, CreatedAt: created})
+	assert.Nil(t, err, "should nil")
+
+	values, err := decodeCursor(cursor, sorts)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, created, values[0], "should decode time")
+	assert.Equal(t, int64(9007199254740993), values[1], "should keep id precision")
+
+	_, err = repo.ListKeyset(ctx, ListQuery{Sort: []SortField{{Field: "created", Desc: true}}, Cursor: cursor, Limit: 10})
+	assert.Nil(t, err, "should nil")
+	require.Len(t, rec.sql, 2)
+	assert.Contains(t, rec.sql[1], `("created_at" < '2024-05-01 10:00:00' OR ("created_at" = '2024-05-01 10:00:00' AND "id" > 9007199254740993))`, "should seek after cursor")
+	assert.Contains(t, rec.sql[1], `ORDER BY "created_at" DESC,"id"`, "should order")
+	assert.Contains(t, rec.sql[1], "LIMIT 11", "should fetch one more row")
+
+	_, err = repo.ListKeyset(ctx, ListQuery{Sort: []SortField{{Field: "name"}}, Cursor: cursor})
+	assert.ErrorIs(t, err, ErrInvalidCursor, "should reject cursor of other sort")
+
+	_, err = repo.ListKeyset(ctx, ListQuery{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor, "should reject cursor")
+}
+
+func TestRepositoryCRUD(t *testing.T) {
+	repo, rec := newTestRepository[repoTestUser](t)
+	ctx := context.Background()
+
+	assert.True(t, repo.SoftDelete(), "should support soft delete")
+
+	_ = repo.Delete(ctx, 1)
+	assert.Contains(t, rec.sql[0], `UPDATE "repo_test_users" SET "deleted_at"=`, "should soft delete")
+
+	_ = repo.HardDelete(ctx, 1)
+	assert.Contains(t, rec.sql[1], `DELETE FROM "repo_test_users" WHERE "id" = 1`, "should hard delete")
+
+	_ = repo.Restore(ctx, 1)
+	assert.Contains(t, rec.sql[2], `SET "deleted_at"=NULL`, "should restore")
+
+	_, _ = repo.FindByID(ctx, 1)
+	assert.Contains(t, rec.sql[3], `WHERE "id" = 1 AND "repo_test_users"."deleted_at" IS NULL`, "should find live row")
+
+	tags, rec := newTestRepository[repoTestTag](t)
+	assert.False(t, tags.SoftDelete(), "should not support soft delete")
+	assert.ErrorIs(t, tags.Restore(ctx, 1), ErrSoftDeleteNotSupported, "should error")
+
+	_ = tags.Delete(ctx, 1)
+	assert.Contains(t, rec.sql[0], `DELETE FROM "repo_test_tags"`, "should delete")
+}
+
+func TestRepositoryJoinTx(t *testing.T) {
+	repo, _ := newTestRepository[repoTestUser](t)
+	other, _ := newTestRepository[repoTestUser](t)
+
+	ctx := ContextWithTx(context.Background(), other.db)
+	assert.Same(t, other.db.Statement.ConnPool, repo.conn(ctx).Statement.ConnPool, "should use transaction")
+}
+
+func TestRepositorySqlite(t *testing.T) {
+	db, err := OpenGormDB(DBConfiguration{DbType: Sqlite})
+	require.Nil(t, err)
+	require.Nil(t, db.AutoMigrate(&repoTestUser{}))
+
+	repo, err := NewRepository[repoTestUser](db, ListSpec{
+		Sortable:   map[string]string{"created": "created_at"},
+		Filterable: map[string]string{"age": "age", "name": "name"},
+	})
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	//rows share created_at in pairs, primary key break the tie
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		user := repoTestUser{Name: strconv.Itoa(i), Age: 20 + i, CreatedAt: base.Add(time.Duration(i/2) * time.Hour)}
+		require.Nil(t, repo.Create(ctx, &user))
+	}
+	require.Nil(t, repo.Delete(ctx, 7))
+
+	q := ListQuery{Sort: []SortField{{Field: "created", Desc: true}}, Limit: 2}
+	var names []string
+	for {
+		page, err := repo.ListKeyset(ctx, q)
+		require.Nil(t, err)
+		assert.Equal(t, int64(6), page.Total, "should count live rows")
+		for _, u := range page.Items {
+			names = append(names, u.Name)
+		}
+		if !page.HasMore {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"4", "5", "2", "3", "0", "1"}, names, "should walk every live row once")
+
+	page, err := repo.ListOffset(ctx, ListQuery{Filters: []Filter{{Field: "age", Op: FilterGte, Value: "24"}}, WithDeleted: true})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(3), page.Total, "should include deleted")
+
+	require.Nil(t, repo.UpdateFields(ctx, 1, map[string]interface{}{"name": "50%_off"}))
+	page, err = repo.ListOffset(ctx, ListQuery{Filters: []Filter{{Field: "name", Op: FilterLike, Value: "%_"}}})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, int64(1), page.Total, "should match wildcard literally")
+
+	assert.Nil(t, repo.Restore(ctx, 7), "should nil")
+	user, err := repo.FindByID(ctx, 7)
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "6", user.Name, "should restore")
+}
@@ -0,0 +1,111 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTenantMissing = errors.New("tenant is missing from context")
+	ErrInvalidTenant = errors.New("invalid tenant")
+)
+
+// TenantInfo identify tenant database, ID is used as connection key when set, otherwise DBName.
+// Schema is only used by schema per tenant mode, see WithSchemaPerTenant.
+type TenantInfo struct {
+	ID     string
+	DBName string
+	DBUser string
+	Schema string
+}
+
+func (t TenantInfo) key() string {
+	if t.ID != "" {
+		return t.ID
+	}
+	return t.DBName
+}
+
+func (t TenantInfo) validate() error {
+	if t.DBName == "" {
+		return fmt.Errorf("%w : DBName is required", ErrInvalidTenant)
+	}
+	return nil
+}
+
+type tenantCtxKey struct{}
+
+// WithTenant return context carrying tenant, read it back with TenantFromContext
+func WithTenant(ctx context.Context, tenant TenantInfo) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// TenantFromContext return tenant set by WithTenant. Legacy "dbname" and "dbuser"
+// string values are still accepted so existing callers keep working.
+func TenantFromContext(ctx context.Context) (TenantInfo, bool) {
+	if tenant, ok := ctx.Value(tenantCtxKey{}).(TenantInfo); ok {
+		return tenant, true
+	}
+
+	dbName, _ := ctx.Value("dbname").(string)
+	dbUser, _ := ctx.Value("dbuser").(string)
+	if dbName == "" && dbUser == "" {
+		return TenantInfo{}, false
+	}
+
+	return TenantInfo{DBName: dbName, DBUser: dbUser}, true
+}
+
+// TenantCredentials override connection settings of the base configuration, empty field keep base value
+type TenantCredentials struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// CredentialProvider resolve credentials of tenant database, e.g. from vault or a tenants table
+type CredentialProvider interface {
+	Credentials(ctx context.Context, tenant TenantInfo) (TenantCredentials, error)
+}
+
+// CredentialProviderFunc adapt plain function to CredentialProvider
+type CredentialProviderFunc func(ctx context.Context, tenant TenantInfo) (TenantCredentials, error)
+
+func (f CredentialProviderFunc) Credentials(ctx context.Context, tenant TenantInfo) (TenantCredentials, error) {
+	return f(ctx, tenant)
+}
+
+// baseCredentials use tenant DBUser with host, port and password of base configuration
+type baseCredentials struct{}
+
+func (baseCredentials) Credentials(ctx context.Context, tenant TenantInfo) (TenantCredentials, error) {
+	return TenantCredentials{Username: tenant.DBUser}, nil
+}
+
+// tenantConfig apply credentials on copy of base configuration
+func tenantConfig(base DBConfiguration, tenant TenantInfo, cred TenantCredentials) (DBConfiguration, error) {
+	cfg := base
+	cfg.DBName = tenant.DBName
+
+	if cred.Host != "" {
+		cfg.Host = cred.Host
+	}
+	if cred.Port != "" {
+		cfg.Port = cred.Port
+	}
+	if cred.Username != "" {
+		cfg.Username = cred.Username
+	}
+	if cred.Password != "" {
+		cfg.Password = cred.Password
+	}
+
+	//sqlite file need no credentials
+	if cfg.Username == "" && cfg.DbType != Sqlite {
+		return cfg, fmt.Errorf("%w : Username is required for tenant %s", ErrInvalidTenant, tenant.key())
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,185 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// lower case only, postgres fold unquoted names such as search_path in the dsn
+var schemaNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+func validateSchemaName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w : Schema is required", ErrInvalidTenant)
+	}
+	if !schemaNameRe.MatchString(name) {
+		return fmt.Errorf("%w : Schema %q is not a valid identifier", ErrInvalidTenant, name)
+	}
+	return nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// ProvisionTenantSchema create postgres schema when missing and apply migrations inside it,
+// every schema keep its own schema_migrations table. migrations may be nil.
+func ProvisionTenantSchema(ctx context.Context, db *sql.DB, dbType string, schemaName string, migrations fs.FS) error {
+	if dbType != Postgresql {
+		return fmt.Errorf("schema per tenant is only supported on postgres")
+	}
+
+	if err := validateSchemaName(schemaName); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+quoteIdentifier(schemaName)); err != nil {
+		return fmt.Errorf("fail create schema %s : %w", schemaName, err)
+	}
+
+	if migrations == nil {
+		return nil
+	}
+
+	if _, err := NewMigrator(db, dbType, migrations, WithMigrationSchema(schemaName)).Up(ctx); err != nil {
+		return fmt.Errorf("fail migrate schema %s : %w", schemaName, err)
+	}
+
+	return nil
+}
+
+const schemaSettingKey = "tools:tenant_schema"
+
+// schemaConnPool run statements of one tenant on the shared pool with search_path
+// set to the tenant schema right before each statement, transactions use SET LOCAL.
+// Shared connections keep the path of the last tenant, so every user of the shared
+// pool must set its own search_path, as Migrator does.
+type schemaConnPool struct {
+	db     *sql.DB
+	schema string
+}
+
+func (p *schemaConnPool) conn(ctx context.Context) (*sql.Conn, error) {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "SET search_path TO "+quoteIdentifier(p.schema)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("fail set search_path : %w", err)
+	}
+	return conn, nil
+}
+
+func (p *schemaConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New("prepared statement is not supported in schema per tenant mode")
+}
+
+func (p *schemaConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	conn, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.ExecContext(ctx, query, args...)
+}
+
+func (p *schemaConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	conn, err := p.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	//Close block until rows are closed, then the connection go back to the pool
+	go conn.Close()
+	return rows, nil
+}
+
+func (p *schemaConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	conn, err := p.conn(ctx)
+	if err != nil {
+		//sql.Row can't be built with own error, canceled context fail it without running
+		return p.db.QueryRowContext(canceledContext, query, args...)
+	}
+
+	row := conn.QueryRowContext(ctx, query, args...)
+	go conn.Close()
+	return row
+}
+
+// BeginTx scope the whole transaction with SET LOCAL, reset by postgres on commit or rollback
+func (p *schemaConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := p.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+quoteIdentifier(p.schema)); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("fail set search_path : %w", err)
+	}
+	return tx, nil
+}
+
+// GetDBConn let gorm DB() return the shared pool
+func (p *schemaConnPool) GetDBConn() (*sql.DB, error) {
+	return p.db, nil
+}
+
+var canceledContext = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// scopeSchema return session of shared db running every statement in schema
+func scopeSchema(shared *gorm.DB, sqlDB *sql.DB, schema string) *gorm.DB {
+	db := shared.Set(schemaSettingKey, schema).Session(&gorm.Session{})
+	db.Statement.ConnPool = &schemaConnPool{db: sqlDB, schema: schema}
+	return db
+}
+
+// registerSchemaScope wrap the pool chosen by replica routing again, so reads sent
+// to a replica stay in the tenant schema too
+func registerSchemaScope(db *gorm.DB) error {
+	scope := func(db *gorm.DB) {
+		v, ok := db.Statement.Settings.Load(schemaSettingKey)
+		if !ok {
+			return
+		}
+
+		switch pool := db.Statement.ConnPool.(type) {
+		case *schemaConnPool, gorm.TxCommitter:
+		case *sql.DB:
+			db.Statement.ConnPool = &schemaConnPool{db: pool, schema: v.(string)}
+		default:
+			db.AddError(fmt.Errorf("schema per tenant can't scope %T", pool))
+		}
+	}
+
+	//after replica routing, before default transaction is opened
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:begin_transaction").Register("tools:schema_scope", scope),
+		cb.Update().Before("gorm:begin_transaction").Register("tools:schema_scope", scope),
+		cb.Delete().Before("gorm:begin_transaction").Register("tools:schema_scope", scope),
+		cb.Query().Before("gorm:query").Register("tools:schema_scope", scope),
+		cb.Row().Before("gorm:row").Register("tools:schema_scope", scope),
+		cb.Raw().Before("gorm:raw").Register("tools:schema_scope", scope),
+	)
+}
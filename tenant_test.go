@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantFromContext(t *testing.T) {
+	_, ok := TenantFromContext(context.Background())
+	assert.False(t, ok, "should not found")
+
+	ctx := WithTenant(context.Background(), TenantInfo{ID: "t1", DBName: "db1", DBUser: "user1"})
+	tenant, ok := TenantFromContext(ctx)
+	assert.True(t, ok, "should found")
+	assert.Equal(t, "db1", tenant.DBName, "should same")
+	assert.Equal(t, "t1", tenant.key(), "should use id as key")
+
+	//legacy string keys
+	ctx = context.WithValue(context.Background(), "dbname", "db2")
+	ctx = context.WithValue(ctx, "dbuser", "user2")
+	tenant, ok = TenantFromContext(ctx)
+	assert.True(t, ok, "should found")
+	assert.Equal(t, TenantInfo{DBName: "db2", DBUser: "user2"}, tenant, "should read legacy keys")
+	assert.Equal(t, "db2", tenant.key(), "should use dbname as key")
+}
+
+func TestDBManagerValidation(t *testing.T) {
+	m := NewDBManager(DBConfiguration{DbType: Postgresql})
+
+	_, err := m.GetConnection(context.Background())
+	assert.ErrorIs(t, err, ErrTenantMissing, "should tenant missing")
+
+	//nil value used to become "%!s(<nil>)"
+	ctx := context.WithValue(context.Background(), "dbuser", "user1")
+	_, err = m.GetConnection(ctx)
+	assert.ErrorIs(t, err, ErrInvalidTenant, "should invalid tenant")
+	assert.Contains(t, err.Error(), "DBName", "should name missing field")
+
+	_, err = m.GetConnection(WithTenant(context.Background(), TenantInfo{DBName: "db1"}))
+	assert.ErrorIs(t, err, ErrInvalidTenant, "should invalid tenant")
+	assert.Contains(t, err.Error(), "Username", "should name missing field")
+
+	fail := errors.New("vault down")
+	m = NewDBManager(DBConfiguration{DbType: Postgresql}, WithCredentialProvider(CredentialProviderFunc(
+		func(ctx context.Context, tenant TenantInfo) (TenantCredentials, error) {
+			return TenantCredentials{}, fail
+		})))
+	_, err = m.GetConnection(WithTenant(context.Background(), TenantInfo{DBName: "db1"}))
+	assert.ErrorIs(t, err, fail, "should return provider error")
+}
+
+func TestTenantConfig(t *testing.T) {
+	base := DBConfiguration{Host: "base", Port: "5432", Username: "admin", Password: "base-pass"}
+
+	cfg, err := tenantConfig(base, TenantInfo{DBName: "db1"}, TenantCredentials{Host: "replica", Username: "u1", Password: "p1"})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, "db1", cfg.DBName, "should use tenant db")
+	assert.Equal(t, "replica", cfg.Host, "should override host")
+	assert.Equal(t, "5432", cfg.Port, "should keep base port")
+	assert.Equal(t, "u1", cfg.Username, "should override user")
+	assert.Equal(t, "p1", cfg.Password, "should override password")
+	assert.Equal(t, "base-pass", base.Password, "should not modify base")
+}
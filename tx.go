@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// TxOptions configure WithTx, zero value use database default isolation and 3 retries
+type TxOptions struct {
+	Isolation  sql.IsolationLevel
+	ReadOnly   bool
+	MaxRetries int           //retries after first attempt, default 3, negative disable retry
+	MinBackoff time.Duration //default 10ms, doubled on every retry
+	MaxBackoff time.Duration //default 1s
+}
+
+type txCtxKey struct{}
+
+// ContextWithTx return context carrying tx, read it back with TxFromContext
+func ContextWithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txCtxKey{}, tx)
+}
+
+// TxFromContext return transaction started by WithTx
+func TxFromContext(ctx context.Context) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(txCtxKey{}).(*gorm.DB)
+	return tx, ok
+}
+
+// DBFromContext return transaction carried by ctx or db, so repository code
+// join the running transaction when there is one
+func DBFromContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+/*
+WithTx run fn in transaction, committed when fn return nil and rolled back otherwise.
+Serialization failures and deadlocks restart the whole transaction with backoff,
+so fn must be safe to run again. When ctx already carry transaction, fn run in a
+savepoint of it and retry is left to the outer WithTx.
+example :
+
+	err := tools.WithTx(ctx, db, nil, func(ctx context.Context, tx *gorm.DB) error {
+		return tx.Create(&order).Error
+	})
+*/
+func WithTx(ctx context.Context, db *gorm.DB, opts *TxOptions, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	run := func(tx *gorm.DB) error {
+		return fn(ContextWithTx(ctx, tx), tx)
+	}
+
+	if outer, ok := TxFromContext(ctx); ok {
+		return outer.WithContext(ctx).Transaction(run)
+	}
+
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+
+	return retryTx(ctx, *opts, func() error {
+		return db.WithContext(ctx).Transaction(run, &sql.TxOptions{
+			Isolation: opts.Isolation,
+			ReadOnly:  opts.ReadOnly,
+		})
+	})
+}
+
+func retryTx(ctx context.Context, opts TxOptions, attempt func() error) error {
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 10 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Second
+	}
+
+	backoff := opts.MinBackoff
+	for i := 0; ; i++ {
+		err := attempt()
+		if err == nil || !IsRetryableTxError(err) || i >= opts.MaxRetries {
+			return err
+		}
+
+		//full jitter spread retries of conflicting transactions
+		wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+// IsRetryableTxError report postgres serialization failure (40001), deadlock (40P01)
+// and mysql deadlock (1213)
+func IsRetryableTxError(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		code := state.SQLState()
+		return code == "40001" || code == "40P01"
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213
+	}
+
+	return false
+}
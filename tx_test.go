@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	assert.True(t, IsRetryableTxError(&pq.Error{Code: "40001"}), "should retry serialization failure")
+	assert.True(t, IsRetryableTxError(fmt.Errorf("wrapped : %w", &pq.Error{Code: "40P01"})), "should retry deadlock")
+	assert.True(t, IsRetryableTxError(&mysqldriver.MySQLError{Number: 1213}), "should retry mysql deadlock")
+
+	assert.False(t, IsRetryableTxError(&pq.Error{Code: "23505"}), "should not retry unique violation")
+	assert.False(t, IsRetryableTxError(&mysqldriver.MySQLError{Number: 1062}), "should not retry duplicate entry")
+	assert.False(t, IsRetryableTxError(errors.New("boom")), "should not retry other error")
+}
+
+func TestRetryTx(t *testing.T) {
+	opts := TxOptions{MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	calls := 0
+	err := retryTx(context.Background(), opts, func() error {
+		calls++
+		if calls < 3 {
+			return &pq.Error{Code: "40001"}
+		}
+		return nil
+	})
+	assert.Nil(t, err, "should nil")
+	assert.Equal(t, 3, calls, "should retry until success")
+
+	calls = 0
+	err = retryTx(context.Background(), opts, func() error {
+		calls++
+		return &pq.Error{Code: "40P01"}
+	})
+	assert.NotNil(t, err, "should error")
+	assert.Equal(t, 4, calls, "should stop after max retries")
+
+	calls = 0
+	err = retryTx(context.Background(), opts, func() error {
+		calls++
+		return errors.New("boom")
+	})
+	assert.NotNil(t, err, "should error")
+	assert.Equal(t, 1, calls, "should not retry")
+
+	calls = 0
+	opts.MaxRetries = -1
+	_ = retryTx(context.Background(), opts, func() error {
+		calls++
+		return &pq.Error{Code: "40001"}
+	})
+	assert.Equal(t, 1, calls, "should disable retry")
+}
+
+func TestTxContext(t *testing.T) {
+	open := func() *gorm.DB {
+		conn, _ := ConnectDB(DBConfiguration{DbType: Postgresql, Host: "127.0.0.1", Port: "1"})
+		db, _ := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{DisableAutomaticPing: true})
+		return db
+	}
+	db, tx := open(), open()
+
+	_, ok := TxFromContext(context.Background())
+	assert.False(t, ok, "should not found")
+
+	ctx := ContextWithTx(context.Background(), tx)
+	got, ok := TxFromContext(ctx)
+	assert.True(t, ok, "should found")
+	assert.Same(t, tx, got, "should same")
+
+	assert.Same(t, tx.Statement.ConnPool, DBFromContext(ctx, db).Statement.ConnPool, "should use transaction")
+	assert.Same(t, db.Statement.ConnPool, DBFromContext(context.Background(), db).Statement.ConnPool, "should use db")
+}
+
+type txTestItem struct {
+	ID   int64
+	Name string
+}
+
+func TestWithTxSqlite(t *testing.T) {
+	db, err := OpenGormDB(DBConfiguration{DbType: Sqlite})
+	if !assert.Nil(t, err, "should nil") {
+		return
+	}
+	assert.Nil(t, db.AutoMigrate(&txTestItem{}), "should nil")
+	ctx := context.Background()
+
+	err = WithTx(ctx, db, nil, func(ctx context.Context, tx *gorm.DB) error {
+		if err := tx.Create(&txTestItem{Name: "kept"}).Error; err != nil {
+			return err
+		}
+
+		//inner failure only roll back its savepoint
+		inner := WithTx(ctx, db, nil, func(ctx context.Context, tx *gorm.DB) error {
+			if err := DBFromContext(ctx, db).Create(&txTestItem{Name: "inner"}).Error; err != nil {
+				return err
+			}
+			return errors.New("boom")
+		})
+		assert.NotNil(t, inner, "should error")
+		return nil
+	})
+	assert.Nil(t, err, "should nil")
+
+	err = WithTx(ctx, db, nil, func(ctx context.Context, tx *gorm.DB) error {
+		if err := tx.Create(&txTestItem{Name: "dropped"}).Error; err != nil {
+			return err
+		}
+		return errors.New("boom")
+	})
+	assert.NotNil(t, err, "should error")
+
+	var names []string
+	assert.Nil(t, db.Model(&txTestItem{}).Order("id").Pluck("name", &names).Error, "should nil")
+	assert.Equal(t, []string{"kept"}, names, "should commit outer only")
+}